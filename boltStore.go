@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// Buckets in the bolt store. The index buckets map value + "\x00" + OLID, or
+// IA identifier, to nothing, so a prefix scan finds the editions or items with
+// a value, in key order.
+var (
+	boltEditions     = []byte("editions")      // OLID to boltEdition.
+	boltEditionIsbn  = []byte("edition_isbn")  // Index of editions by ISBN 13.
+	boltEditionOcaid = []byte("edition_ocaid") // Index of editions by ocaid.
+	boltIA           = []byte("ia")            // IA identifier to boltItem.
+	boltIAIsbn       = []byte("ia_isbn")       // Index of IA items by ISBN 13.
+
+	// A load's records until it's folded into the buckets above, in
+	// boltEditions and boltIA buckets of its own. See boltWriter.
+	boltStaging = []byte("staging")
+	boltMeta    = []byte("meta") // Holds boltLoadState.
+)
+
+// boltLoadState is the boltMeta key for how far a load has got: boltLoading
+// while it's staging records, then boltFolding while it's folding them in.
+// It's deleted once the load is folded in or aborted.
+var (
+	boltLoadState = []byte("load_state")
+	boltLoading   = []byte("loading")
+	boltFolding   = []byte("folding")
+)
+
+// boltRecoverBatchSize is how many staged records are folded in per
+// transaction when newBoltStore finishes an interrupted load.
+const boltRecoverBatchSize = 1000
+
+// boltStore is the Store backed by bbolt, an embedded key-value store that
+// doesn't need cgo. It holds editions and IA items, as JSON, and ignores other
+// records, so it can only reconcile on ISBNs.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		// Stores from before ia_isbn need it built.
+		indexIA := tx.Bucket(boltIAIsbn) == nil
+
+		for _, bucket := range [][]byte{boltEditions, boltEditionIsbn, boltEditionOcaid, boltIA, boltIAIsbn, boltMeta} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+
+		if indexIA {
+			return tx.Bucket(boltIA).ForEach(func(k, v []byte) error {
+				var item boltItem
+				if err := json.Unmarshal(v, &item); err != nil {
+					return err
+				}
+				for _, isbn := range item.Isbns {
+					if err := tx.Bucket(boltIAIsbn).Put(indexKey(isbn, item.Identifier), nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := recoverBoltLoad(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+// recoverBoltLoad finishes or undoes a load that was interrupted, by a crash
+// say, before it was folded in or aborted. One that had started folding in its
+// staged records is finished, and one that was still staging them is dropped,
+// so the store never keeps part of a load.
+func recoverBoltLoad(db *bolt.DB) error {
+	var state []byte
+	if err := db.View(func(tx *bolt.Tx) error {
+		state = append(state, tx.Bucket(boltMeta).Get(boltLoadState)...)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.Equal(state, boltFolding):
+		return foldBoltStaging(db, boltRecoverBatchSize)
+	case bytes.Equal(state, boltLoading):
+		return dropBoltStaging(db)
+	}
+
+	return nil
+}
+
+// boltEdition is how an OpenLibraryEdition is stored: the fields the SQLite
+// store keeps in ol and edition_isbn.
+type boltEdition struct {
+	Olid          string    `json:"olid"`
+	Ocaid         string    `json:"ocaid,omitempty"`
+	Isbn13        string    `json:"isbn_13,omitempty"`
+	Isbns         []string  `json:"isbns,omitempty"`
+	Revision      int       `json:"revision,omitempty"`
+	LastModified  time.Time `json:"last_modified,omitempty"`
+	Title         string    `json:"title,omitempty"`
+	Subtitle      string    `json:"subtitle,omitempty"`
+	Publishers    []string  `json:"publishers,omitempty"`
+	PublishDate   string    `json:"publish_date,omitempty"`
+	NumberOfPages int       `json:"number_of_pages,omitempty"`
+	Languages     []string  `json:"languages,omitempty"`
+	SourceRecords []string  `json:"source_records,omitempty"`
+}
+
+func toBoltEdition(e *OpenLibraryEdition) *boltEdition {
+	return &boltEdition{
+		Olid: e.olid, Ocaid: e.ocaid, Isbn13: e.isbn13, Isbns: e.isbns, Revision: e.revision, LastModified: e.lastModified,
+		Title: e.title, Subtitle: e.subtitle, Publishers: e.publishers, PublishDate: e.publishDate,
+		NumberOfPages: e.numberOfPages, Languages: e.languages, SourceRecords: e.sourceRecords,
+	}
+}
+
+func (b *boltEdition) edition() *OpenLibraryEdition {
+	return &OpenLibraryEdition{
+		olid: b.Olid, ocaid: b.Ocaid, isbn13: b.Isbn13, isbns: b.Isbns, revision: b.Revision, lastModified: b.LastModified,
+		title: b.Title, subtitle: b.Subtitle, publishers: b.Publishers, publishDate: b.PublishDate,
+		numberOfPages: b.NumberOfPages, languages: b.Languages, sourceRecords: b.SourceRecords,
+	}
+}
+
+// boltItem is how an IAItem is stored.
+type boltItem struct {
+	Identifier  string   `json:"identifier"`
+	Isbns       []string `json:"isbns,omitempty"`
+	OlEdition   string   `json:"openlibrary_edition,omitempty"`
+	OlWork      string   `json:"openlibrary_work,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Date        string   `json:"date,omitempty"`
+	Publishers  []string `json:"publishers,omitempty"`
+	ImageCount  int      `json:"imagecount,omitempty"`
+	Languages   []string `json:"languages,omitempty"`
+	Creators    []string `json:"creators,omitempty"`
+}
+
+func toBoltItem(i *IAItem) *boltItem {
+	return &boltItem{
+		Identifier: i.identifier, Isbns: i.isbns, OlEdition: i.olEdition, OlWork: i.olWork, Collections: i.collections,
+		Title: i.title, Date: i.date, Publishers: i.publishers, ImageCount: i.imageCount, Languages: i.languages,
+		Creators: i.creators,
+	}
+}
+
+func indexKey(value, key string) []byte {
+	return []byte(value + "\x00" + key)
+}
+
+// writer returns a boltWriter that stages the load's records, batchSize to a
+// transaction. The bolt store doesn't keep runs, so each load adds to or
+// replaces what's there, and run is ignored.
+func (s *boltStore) writer(run *loadRun, batchSize int) (recordWriter, error) {
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		staging, err := tx.CreateBucket(boltStaging)
+		if err != nil {
+			return err
+		}
+		for _, bucket := range [][]byte{boltEditions, boltIA} {
+			if _, err := staging.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket(boltMeta).Put(boltLoadState, boltLoading)
+	}); err != nil {
+		return nil, err
+	}
+
+	return &boltWriter{db: s.db, batchSize: batchSize}, nil
+}
+
+func (s *boltStore) endRun(run *loadRun, loadErr error) error {
+	return nil
+}
+
+func (s *boltStore) editionByOlid(olid string) (*OpenLibraryEdition, error) {
+	var edition *OpenLibraryEdition
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		edition, err = getBoltEdition(tx, olid)
+		return err
+	})
+
+	return edition, err
+}
+
+func (s *boltStore) editionsByIsbn(isbn13 string) ([]*OpenLibraryEdition, error) {
+	return s.editionsByIndex(boltEditionIsbn, isbn13)
+}
+
+func (s *boltStore) editionsByOcaid(ocaid string) ([]*OpenLibraryEdition, error) {
+	return s.editionsByIndex(boltEditionOcaid, ocaid)
+}
+
+func (s *boltStore) editions(fn func(e *OpenLibraryEdition) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEditions).ForEach(func(_, v []byte) error {
+			var b boltEdition
+			if err := json.Unmarshal(v, &b); err != nil {
+				return err
+			}
+			return fn(b.edition())
+		})
+	})
+}
+
+// linkCandidates finds the IA items with no openlibrary_edition that share an
+// ISBN with exactly one edition, where that edition has no ocaid, as
+// isbnCandidatesQuery does. LCCNs, OCLC numbers and authors aren't stored, so
+// there are no other reasons, and no disagreements. Editions are read in OLID
+// order, and only one edition's candidates are held at a time, to be sorted
+// by ocaid, so the order is the SQLite store's.
+func (s *boltStore) linkCandidates(fn func(c *LinkCandidate) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEditions).ForEach(func(_, v []byte) error {
+			var b boltEdition
+			if err := json.Unmarshal(v, &b); err != nil {
+				return err
+			}
+			if b.Ocaid != "" {
+				return nil
+			}
+			edition := b.edition()
+
+			// The smallest shared ISBN is reported, as with min() in SQL.
+			isbns := append([]string(nil), edition.isbns...)
+			sort.Strings(isbns)
+
+			var candidates []LinkCandidate
+			seen := make(map[string]bool)
+			for _, isbn := range isbns {
+				if olids := boltIndexKeys(tx, boltEditionIsbn, isbn); len(olids) != 1 || olids[0] != edition.olid {
+					continue
+				}
+
+				for _, identifier := range boltIndexKeys(tx, boltIAIsbn, isbn) {
+					if seen[identifier] {
+						continue
+					}
+					seen[identifier] = true
+
+					item, err := getBoltItem(tx, identifier)
+					if err != nil {
+						return err
+					}
+					if item.OlEdition != "" {
+						continue
+					}
+
+					candidates = append(candidates, LinkCandidate{
+						olid: edition.olid, ocaid: item.Identifier, isbn13: isbn, reason: ReasonUniqueIsbn,
+						score: scoreMatch(&matchMetadata{
+							olTitle:       edition.title,
+							olSubtitle:    edition.subtitle,
+							olPublishDate: edition.publishDate,
+							olPublishers:  strings.Join(edition.publishers, ";"),
+							olPages:       edition.numberOfPages,
+							olLanguages:   strings.Join(edition.languages, ";"),
+							iaTitle:       item.Title,
+							iaDate:        item.Date,
+							iaPublishers:  strings.Join(item.Publishers, ";"),
+							iaImageCount:  item.ImageCount,
+							iaLanguages:   strings.Join(item.Languages, ";"),
+						}),
+					})
+				}
+			}
+
+			sort.Slice(candidates, func(i, j int) bool { return candidates[i].ocaid < candidates[j].ocaid })
+			for i := range candidates {
+				if err := fn(&candidates[i]); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+}
+
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
+
+// editionsByIndex returns the editions with value in the index bucket.
+func (s *boltStore) editionsByIndex(bucket []byte, value string) ([]*OpenLibraryEdition, error) {
+	var editions []*OpenLibraryEdition
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, olid := range boltIndexKeys(tx, bucket, value) {
+			edition, err := getBoltEdition(tx, olid)
+			if err != nil {
+				return err
+			}
+			editions = append(editions, edition)
+		}
+		return nil
+	})
+
+	return editions, err
+}
+
+// boltIndexKeys returns the OLIDs or IA identifiers with value in the index
+// bucket, in order.
+func boltIndexKeys(tx *bolt.Tx, bucket []byte, value string) []string {
+	var keys []string
+	prefix := indexKey(value, "")
+	c := tx.Bucket(bucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, string(k[len(prefix):]))
+	}
+
+	return keys
+}
+
+// getBoltEdition returns the edition with olid, or ErrorNotFound.
+func getBoltEdition(tx *bolt.Tx, olid string) (*OpenLibraryEdition, error) {
+	v := tx.Bucket(boltEditions).Get([]byte(olid))
+	if v == nil {
+		return nil, fmt.Errorf("%v: %w", olid, ErrorNotFound)
+	}
+
+	var b boltEdition
+	if err := json.Unmarshal(v, &b); err != nil {
+		return nil, err
+	}
+
+	return b.edition(), nil
+}
+
+// getBoltItem returns the IA item with identifier, or ErrorNotFound.
+func getBoltItem(tx *bolt.Tx, identifier string) (*boltItem, error) {
+	v := tx.Bucket(boltIA).Get([]byte(identifier))
+	if v == nil {
+		return nil, fmt.Errorf("%v: %w", identifier, ErrorNotFound)
+	}
+
+	var item boltItem
+	if err := json.Unmarshal(v, &item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// boltWriter is the recordWriter for the bolt store. bbolt keeps a
+// transaction's changes in memory until it commits, so a load is staged in
+// transactions of batchSize records, under boltStaging. Closing the writer
+// folds the staged records into the store, again in batches, and aborting it
+// drops them, so a failed load leaves the store as it was. boltLoadState
+// records which of those a load had got to, for recoverBoltLoad. Other OL
+// records than editions, and MARC records, are skipped.
+type boltWriter struct {
+	db        *bolt.DB
+	tx        *bolt.Tx // The batch being staged, if any.
+	batchSize int
+	count     int // Records staged in tx.
+}
+
+func (w *boltWriter) add(record Record) error {
+	var bucket, key []byte
+	var value interface{}
+	switch r := record.(type) {
+	case *OpenLibraryEdition:
+		bucket, key, value = boltEditions, []byte(r.olid), toBoltEdition(r)
+	case *IAItem:
+		bucket, key, value = boltIA, []byte(r.identifier), toBoltItem(r)
+	case *OpenLibraryWork, *OpenLibraryAuthor, *OpenLibraryRedirect, *OpenLibraryDeletion, *MARCRecord:
+		return nil
+	default:
+		return fmt.Errorf("%T: %w", record, ErrorUnsupportedRecord)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if w.tx == nil {
+		if w.tx, err = w.db.Begin(true); err != nil {
+			return err
+		}
+	}
+	if err := w.tx.Bucket(boltStaging).Bucket(bucket).Put(key, data); err != nil {
+		return err
+	}
+
+	w.count++
+	if w.count < w.batchSize {
+		return nil
+	}
+
+	w.count = 0
+	tx := w.tx
+	w.tx = nil
+	return tx.Commit()
+}
+
+func (w *boltWriter) close() error {
+	if w.tx != nil {
+		tx := w.tx
+		w.tx = nil
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return foldBoltStaging(w.db, w.batchSize)
+}
+
+func (w *boltWriter) abort() error {
+	if w.tx != nil {
+		w.tx.Rollback()
+		w.tx = nil
+	}
+
+	return dropBoltStaging(w.db)
+}
+
+// foldBoltStaging puts a load's staged records into the store, batchSize to a
+// transaction, then deletes what's left of boltStaging. Once it's started, an
+// interrupted fold is finished by recoverBoltLoad, and as putting a record
+// replaces any earlier version of it, it can safely start over.
+func foldBoltStaging(db *bolt.DB, batchSize int) error {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMeta).Put(boltLoadState, boltFolding)
+	}); err != nil {
+		return err
+	}
+
+	for done := false; !done; {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			staging := tx.Bucket(boltStaging)
+			count := 0
+			for _, bucket := range [][]byte{boltEditions, boltIA} {
+				// Folded records are deleted, so the next is always first.
+				c := staging.Bucket(bucket).Cursor()
+				for k, v := c.First(); k != nil && count < batchSize; k, v = c.First() {
+					if err := foldBoltRecord(tx, bucket, v); err != nil {
+						return err
+					}
+					if err := c.Delete(); err != nil {
+						return err
+					}
+					count++
+				}
+			}
+
+			if count < batchSize {
+				done = true
+				if err := tx.DeleteBucket(boltStaging); err != nil {
+					return err
+				}
+				return tx.Bucket(boltMeta).Delete(boltLoadState)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// foldBoltRecord puts a staged record from bucket into the store.
+func foldBoltRecord(tx *bolt.Tx, bucket, data []byte) error {
+	if bytes.Equal(bucket, boltEditions) {
+		var b boltEdition
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		return putBoltEdition(tx, b.edition())
+	}
+
+	var item boltItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return err
+	}
+	return putBoltItem(tx, &item)
+}
+
+// dropBoltStaging deletes a load's staged records. Deleting a bucket frees its
+// pages rather than changing them, so it's one transaction however big the
+// load was.
+func dropBoltStaging(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltStaging); err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+			return err
+		}
+		return tx.Bucket(boltMeta).Delete(boltLoadState)
+	})
+}
+
+// putBoltEdition stores an edition and indexes it, replacing the index entries
+// of an earlier version of it.
+func putBoltEdition(tx *bolt.Tx, e *OpenLibraryEdition) error {
+	editions, isbns, ocaids := tx.Bucket(boltEditions), tx.Bucket(boltEditionIsbn), tx.Bucket(boltEditionOcaid)
+
+	if old, err := getBoltEdition(tx, e.olid); err == nil {
+		for _, isbn := range old.isbns {
+			if err := isbns.Delete(indexKey(isbn, old.olid)); err != nil {
+				return err
+			}
+		}
+		if old.ocaid != "" {
+			if err := ocaids.Delete(indexKey(old.ocaid, old.olid)); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := json.Marshal(toBoltEdition(e))
+	if err != nil {
+		return err
+	}
+	if err := editions.Put([]byte(e.olid), data); err != nil {
+		return err
+	}
+
+	for _, isbn := range e.isbns {
+		if err := isbns.Put(indexKey(isbn, e.olid), nil); err != nil {
+			return err
+		}
+	}
+
+	if e.ocaid != "" {
+		return ocaids.Put(indexKey(e.ocaid, e.olid), nil)
+	}
+	return nil
+}
+
+// putBoltItem stores an IA item and indexes it, replacing the index entries of
+// an earlier version of it.
+func putBoltItem(tx *bolt.Tx, item *boltItem) error {
+	items, isbns := tx.Bucket(boltIA), tx.Bucket(boltIAIsbn)
+
+	if old, err := getBoltItem(tx, item.Identifier); err == nil {
+		for _, isbn := range old.Isbns {
+			if err := isbns.Delete(indexKey(isbn, old.Identifier)); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err := items.Put([]byte(item.Identifier), data); err != nil {
+		return err
+	}
+
+	for _, isbn := range item.Isbns {
+		if err := isbns.Put(indexKey(isbn, item.Identifier), nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic bytes at the start of each compressed format, for sniffing streams
+// that have no file extension, such as stdin.
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// isCompressed reports whether filename looks like a compressed dump, based on
+// its extension. Compressed dumps can't be split into chunks with Seek, so
+// they must be streamed instead.
+func isCompressed(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz", ".bz2", ".zst":
+		return true
+	}
+	return false
+}
+
+// isSeekable reports whether filename is a regular file. Anything else, such
+// as a named pipe, can't be split into chunks with Seek.
+func isSeekable(filename string) (bool, error) {
+	fstat, err := os.Stat(filename)
+	if err != nil {
+		return false, err
+	}
+	return fstat.Mode().IsRegular(), nil
+}
+
+// dumpReader wraps a decompressor so that closing it also closes the
+// underlying file.
+type dumpReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *dumpReader) Close() error {
+	var firstErr error
+	for _, c := range d.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// openDump opens filename and, if it's compressed, wraps it in the matching
+// decompressor. Supports .gz, .bz2 and .zst; anything else is returned as is.
+func openDump(filename string) (io.ReadCloser, error) {
+	return openDumpTee(filename, io.Discard)
+}
+
+// openDumpTee is openDump, but what's read from the file, before it's
+// decompressed, is also written to w.
+func openDumpTee(filename string, w io.Writer) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decompress(strings.ToLower(filepath.Ext(filename)), io.TeeReader(f, w), f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// decompressStream sniffs the first bytes of r and, if they match a supported
+// compression format, wraps r in the matching decompressor. This is the
+// counterpart to openDump for streams without a name, such as stdin.
+func decompressStream(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	// An error here just means the stream is too short to be compressed.
+	magic, _ := br.Peek(len(zstdMagic))
+
+	var ext string
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		ext = ".gz"
+	case bytes.HasPrefix(magic, bzip2Magic):
+		ext = ".bz2"
+	case bytes.HasPrefix(magic, zstdMagic):
+		ext = ".zst"
+	}
+
+	closer, ok := r.(io.Closer)
+	if !ok {
+		closer = io.NopCloser(nil)
+	}
+
+	return decompress(ext, br, closer)
+}
+
+// decompress wraps r in the decompressor for ext, one of .gz, .bz2 or .zst.
+// Any other ext returns r as is. Closing the result closes closer, which is
+// usually the file r reads from.
+func decompress(ext string, r io.Reader, closer io.Closer) (io.ReadCloser, error) {
+	switch ext {
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &dumpReader{Reader: gz, closers: []io.Closer{gz, closer}}, nil
+
+	case ".bz2":
+		return &dumpReader{Reader: bzip2.NewReader(r), closers: []io.Closer{closer}}, nil
+
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &dumpReader{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), closer}}, nil
+	}
+
+	return &dumpReader{Reader: r, closers: []io.Closer{closer}}, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressionTestLines = `/type/edition	/books/OL001M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL001M", "isbn_13": ["9788955565683"], "ocaid": "IA001"}
+/type/author	/authors/OL001A	6	2020-12-22T19:20:44.396666	{"key": "/authors/OL001A"}
+/type/edition	/books/OL002M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL002M", "isbn_10": ["0135043948"], "ocaid": "IA002"}
+`
+
+// writeCompressed writes data to a file in dir, compressed according to the
+// extension of name.
+func writeCompressed(t *testing.T, dir, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var w io.WriteCloser
+	switch filepath.Ext(name) {
+	case ".gz":
+		w = gzip.NewWriter(f)
+	case ".zst":
+		w, err = zstd.NewWriter(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unsupported extension for %s", name)
+	}
+
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestIsCompressed(t *testing.T) {
+	tests := []struct {
+		filename string
+		exp      bool
+	}{
+		{filename: "ol_dump_2023-01-31.txt.gz", exp: true},
+		{filename: "ol_dump_2023-01-31.txt.GZ", exp: true},
+		{filename: "ol_dump_2023-01-31.txt.bz2", exp: true},
+		{filename: "ol_dump_2023-01-31.txt.zst", exp: true},
+		{filename: "ol_dump_2023-01-31.txt", exp: false},
+	}
+
+	for _, tc := range tests {
+		if res := isCompressed(tc.filename); res != tc.exp {
+			t.Fatalf("%s: expected %v, but got %v", tc.filename, tc.exp, res)
+		}
+	}
+}
+
+func TestOpenDump(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"dump.txt.gz", "dump.txt.zst"} {
+		t.Run(name, func(t *testing.T) {
+			path := writeCompressed(t, dir, name, compressionTestLines)
+
+			r, err := openDump(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			res, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(res) != compressionTestLines {
+				t.Fatalf("expected %q, but got %q", compressionTestLines, res)
+			}
+		})
+	}
+}
+
+func TestStreamLines(t *testing.T) {
+	linesCh := make(chan [][]byte)
+	var batches [][][]byte
+
+	go func() {
+		defer close(linesCh)
+		if err := streamLines(context.Background(), strings.NewReader("a\nb\nc\nd\ne"), bufio.ScanLines, 2, linesCh); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for batch := range linesCh {
+		batches = append(batches, batch)
+	}
+
+	exp := [][][]byte{
+		{[]byte("a"), []byte("b")},
+		{[]byte("c"), []byte("d")},
+		{[]byte("e")},
+	}
+	if !reflect.DeepEqual(exp, batches) {
+		t.Fatalf("expected %q, but got %q", exp, batches)
+	}
+}
+
+// TestGetEditionsGzip verifies a compressed dump is streamed through the
+// parsers rather than chunked.
+func TestGetEditionsGzip(t *testing.T) {
+	var resEditions []*OpenLibraryEdition
+	recordsCh := make(chan Record)
+	doneCh := make(chan struct{})
+	errCh := make(chan error)
+	inFile := writeCompressed(t, t.TempDir(), "dump.txt.gz", compressionTestLines)
+	expEditions := []*OpenLibraryEdition{
+		{olid: "OL001M", ocaid: "IA001", isbn10: "", isbn13: "9788955565683", isbns: []string{"9788955565683"}, isbnStatus: IsbnValid, revision: 6, lastModified: testLastModified},
+		{olid: "OL002M", ocaid: "IA002", isbn10: "0135043948", isbn13: "9780135043943", isbns: []string{"9780135043943"}, isbnStatus: IsbnValid, revision: 6, lastModified: testLastModified},
+	}
+
+	go func() {
+		for record := range recordsCh {
+			if edition, ok := record.(*OpenLibraryEdition); ok {
+				resEditions = append(resEditions, edition)
+			}
+		}
+		defer close(doneCh)
+	}()
+
+	if err := getRecords(context.Background(), inFile, NewOpenLibraryParser(allEditionFields(), time.Time{}), io.Discard, recordsCh, doneCh, errCh, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(resEditions, func(i, j int) bool {
+		return resEditions[i].olid < resEditions[j].olid
+	})
+
+	if !reflect.DeepEqual(expEditions, resEditions) {
+		t.Fatalf("expected %v, but got %v", expEditions, resEditions)
+	}
+}
+
+func TestIsSeekable(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump.txt")
+	if err := os.WriteFile(path, []byte(compressionTestLines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if seekable, err := isSeekable(path); err != nil || !seekable {
+		t.Fatalf("expected %s to be seekable, got %v (%v)", path, seekable, err)
+	}
+
+	// Directories, like pipes, aren't regular files.
+	if seekable, err := isSeekable(dir); err != nil || seekable {
+		t.Fatalf("expected %s not to be seekable, got %v (%v)", dir, seekable, err)
+	}
+}
+
+// TestDecompressStream verifies compression is detected from the stream
+// itself, with plain text passed through untouched.
+func TestDecompressStream(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"dump.txt.gz", "dump.txt.zst", "dump.txt"} {
+		t.Run(name, func(t *testing.T) {
+			var data []byte
+			var err error
+			if filepath.Ext(name) == ".txt" {
+				data = []byte(compressionTestLines)
+			} else {
+				data, err = os.ReadFile(writeCompressed(t, dir, name, compressionTestLines))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r, err := decompressStream(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			res, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(res) != compressionTestLines {
+				t.Fatalf("expected %q, but got %q", compressionTestLines, res)
+			}
+		})
+	}
+}
+
+// TestGetRecordsFromReader streams a gzipped dump from a plain io.Reader, as
+// when piping from curl.
+func TestGetRecordsFromReader(t *testing.T) {
+	var resOlids []string
+	recordsCh := make(chan Record)
+	doneCh := make(chan struct{})
+	errCh := make(chan error)
+
+	data, err := os.ReadFile(writeCompressed(t, t.TempDir(), "dump.txt.gz", compressionTestLines))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for record := range recordsCh {
+			resOlids = append(resOlids, record.(OpenLibraryRecord).getOlid())
+		}
+		defer close(doneCh)
+	}()
+
+	if err := getRecordsFromReader(context.Background(), bytes.NewReader(data), NewOpenLibraryParser(nil, time.Time{}), io.Discard, recordsCh, doneCh, errCh); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(resOlids)
+	expOlids := []string{"OL001A", "OL001M", "OL002M"}
+	if !reflect.DeepEqual(expOlids, resOlids) {
+		t.Fatalf("expected %v, but got %v", expOlids, resOlids)
+	}
+}
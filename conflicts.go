@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConflictKind is the type of problem a Conflict describes.
+type ConflictKind string
+
+const (
+	ConflictSharedOcaid ConflictKind = "shared_ocaid"    // One ocaid on several editions.
+	ConflictIsbnScans   ConflictKind = "isbn_many_scans" // One ISBN on editions with different ocaids.
+	ConflictIAMismatch  ConflictKind = "ia_mismatch"     // An edition's ocaid names an IA item linked to another edition.
+)
+
+// Conflict is a group of editions that need a librarian to look at them.
+type Conflict struct {
+	kind   ConflictKind
+	key    string   // The shared ocaid or ISBN.
+	olids  []string // For ConflictIAMismatch, the edition then the IA item's openlibrary_edition.
+	ocaids []string
+}
+
+// sharedOcaidQuery finds ocaids on more than one edition, at least one of
+// them last modified after ?1 unless it's empty.
+const sharedOcaidQuery = `
+  SELECT ocaid, group_concat(DISTINCT edition_id), ocaid
+  FROM ol
+  WHERE coalesce(ocaid, '') != ''
+  GROUP BY ocaid
+  HAVING count(DISTINCT edition_id) > 1
+    AND (?1 = '' OR max(last_modified) > ?1)
+  ORDER BY ocaid`
+
+// isbnScansQuery finds ISBNs shared by editions with different ocaids, at
+// least one of them last modified after ?1 unless it's empty. Editions
+// without an ocaid aren't a conflict, so they're left out.
+const isbnScansQuery = `
+  SELECT ei.isbn_13, group_concat(DISTINCT ei.edition_id), group_concat(DISTINCT ol.ocaid)
+  FROM edition_isbn ei
+  JOIN ol ON ol.edition_id = ei.edition_id
+  WHERE coalesce(ol.ocaid, '') != ''
+  GROUP BY ei.isbn_13
+  HAVING count(DISTINCT ol.ocaid) > 1
+    AND (?1 = '' OR max(ol.last_modified) > ?1)
+  ORDER BY ei.isbn_13`
+
+// iaMismatchQuery finds editions, last modified after ?1 unless it's empty,
+// whose ocaid is an IA item with a different openlibrary_edition. Some of
+// these are redirects, which getConflicts filters out.
+const iaMismatchQuery = `
+  SELECT ol.edition_id, ol.ocaid, ia.ol_edition_id
+  FROM ol
+  JOIN ia ON ia.identifier = ol.ocaid
+  WHERE coalesce(ia.ol_edition_id, '') != ''
+    AND ia.ol_edition_id != ol.edition_id
+    AND (?1 = '' OR ol.last_modified > ?1)
+  ORDER BY ol.ocaid, ol.edition_id`
+
+// getConflicts calls fn with each Conflict in the DB, grouped by kind. Unless
+// modifiedAfter is zero, only conflicts involving an edition last modified
+// after it are included.
+func getConflicts(db *sql.DB, modifiedAfter time.Time, fn func(c *Conflict) error) error {
+	after := modifiedAfterArg(modifiedAfter)
+	if err := queryGroupedConflicts(db, ConflictSharedOcaid, sharedOcaidQuery, after, fn); err != nil {
+		return err
+	}
+
+	if err := queryGroupedConflicts(db, ConflictIsbnScans, isbnScansQuery, after, fn); err != nil {
+		return err
+	}
+
+	resolver, err := getRedirectResolver(db)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(iaMismatchQuery, after)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var olid, ocaid, iaEdition string
+		if err := rows.Scan(&olid, &ocaid, &iaEdition); err != nil {
+			return err
+		}
+
+		// The IA item points at a redirect to this very edition. A deleted or
+		// looping edition is still a conflict.
+		if resolved, err := resolver.resolve(iaEdition); err == nil && resolved == olid {
+			continue
+		}
+
+		if err := fn(&Conflict{kind: ConflictIAMismatch, key: ocaid, olids: []string{olid, iaEdition}, ocaids: []string{ocaid}}); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// queryGroupedConflicts runs query with the modifiedAfterArg after, which
+// returns the key and the comma separated OLIDs and ocaids of each group, and
+// calls fn with each as a Conflict of kind.
+func queryGroupedConflicts(db *sql.DB, kind ConflictKind, query, after string, fn func(c *Conflict) error) error {
+	rows, err := db.Query(query, after)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, olids, ocaids string
+		if err := rows.Scan(&key, &olids, &ocaids); err != nil {
+			return err
+		}
+
+		// group_concat's order isn't defined, so sort for stable output.
+		c := Conflict{kind: kind, key: key, olids: strings.Split(olids, ","), ocaids: strings.Split(ocaids, ",")}
+		sort.Strings(c.olids)
+		sort.Strings(c.ocaids)
+
+		if err := fn(&c); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// runConflicts writes the conflicts in an already loaded DB, narrowed to
+// editions last modified after modifiedAfter unless it's zero, to w.
+func runConflicts(dbName string, modifiedAfter time.Time, w reportWriter) error {
+	db, err := getDB(dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := w.writeHeader([]string{"kind", "key", "olids", "ocaids"}); err != nil {
+		return err
+	}
+
+	return getConflicts(db, modifiedAfter, func(c *Conflict) error {
+		return w.writeRow(string(c.kind), c.key, c.olids, c.ocaids)
+	})
+}
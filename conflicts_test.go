@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetConflicts(t *testing.T) {
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadTestRecords(t, db, newOLWriter,
+		// IA001 is on two editions, one of them modified recently.
+		&OpenLibraryEdition{olid: "OL001M", ocaid: "IA001"},
+		&OpenLibraryEdition{olid: "OL002M", ocaid: "IA001", lastModified: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+		// One ISBN, two scans. OL005M has no scan, so it isn't part of the conflict.
+		&OpenLibraryEdition{olid: "OL003M", ocaid: "IA003", isbns: []string{"9780141439518"}},
+		&OpenLibraryEdition{olid: "OL004M", ocaid: "IA004", isbns: []string{"9780141439518"}},
+		&OpenLibraryEdition{olid: "OL005M", isbns: []string{"9780141439518"}},
+		// One ISBN, one scan: no conflict.
+		&OpenLibraryEdition{olid: "OL006M", ocaid: "IA006", isbns: []string{"9780135043943"}},
+		&OpenLibraryEdition{olid: "OL007M", ocaid: "IA006", isbns: []string{"9780135043943"}},
+		// IA008 says it's OL009M.
+		&OpenLibraryEdition{olid: "OL008M", ocaid: "IA008", lastModified: time.Date(2023, 6, 1, 12, 30, 0, 0, time.UTC)},
+		// IA010 says it's OL011M, which redirects here, so there's no conflict.
+		&OpenLibraryEdition{olid: "OL010M", ocaid: "IA010"},
+		&OpenLibraryRedirect{olid: "OL011M", location: "OL010M"},
+	)
+
+	loadTestRecords(t, db, newIAWriter,
+		NewIAItem("IA003", nil, "OL003M", "", nil),
+		NewIAItem("IA008", nil, "OL009M", "", nil),
+		NewIAItem("IA010", nil, "OL011M", "", nil),
+	)
+
+	tests := []struct {
+		name          string
+		modifiedAfter time.Time
+		exp           []Conflict
+	}{
+		{
+			name: "All",
+			exp: []Conflict{
+				{kind: ConflictSharedOcaid, key: "IA001", olids: []string{"OL001M", "OL002M"}, ocaids: []string{"IA001"}},
+				{kind: ConflictSharedOcaid, key: "IA006", olids: []string{"OL006M", "OL007M"}, ocaids: []string{"IA006"}},
+				{kind: ConflictIsbnScans, key: "9780141439518", olids: []string{"OL003M", "OL004M"}, ocaids: []string{"IA003", "IA004"}},
+				{kind: ConflictIAMismatch, key: "IA008", olids: []string{"OL008M", "OL009M"}, ocaids: []string{"IA008"}},
+			},
+		},
+		{
+			// Only conflicts involving an edition modified since.
+			name: "ModifiedAfter", modifiedAfter: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+			exp: []Conflict{
+				{kind: ConflictIAMismatch, key: "IA008", olids: []string{"OL008M", "OL009M"}, ocaids: []string{"IA008"}},
+			},
+		},
+		{
+			name: "ModifiedAfterDate", modifiedAfter: time.Date(2023, 5, 31, 0, 0, 0, 0, time.UTC),
+			exp: []Conflict{
+				{kind: ConflictSharedOcaid, key: "IA001", olids: []string{"OL001M", "OL002M"}, ocaids: []string{"IA001"}},
+				{kind: ConflictIAMismatch, key: "IA008", olids: []string{"OL008M", "OL009M"}, ocaids: []string{"IA008"}},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var resConflicts []Conflict
+			if err := getConflicts(db, tc.modifiedAfter, func(c *Conflict) error {
+				resConflicts = append(resConflicts, *c)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(tc.exp, resConflicts) {
+				t.Fatalf("expected %v, but got %v", tc.exp, resConflicts)
+			}
+		})
+	}
+}
@@ -3,6 +3,23 @@ package main
 // var CHUNKSIZE = int64(1000 * 1000 * 1000)
 var CHUNKSIZE = int64(10 * 1000 * 1000)
 
+// LINEBATCHSIZE is how many lines are handed to a parser GoRoutine at once when
+// streaming a dump that can't be chunked, such as a .gz file.
+const LINEBATCHSIZE int = 1000
+
+// DBFILE is the DB's file name, for where a plain path is needed, such as
+// ATTACH DATABASE.
+const DBFILE string = "reconcile-go.db"
+
 // Set some SQLite options, per https://avi.im/blag/2021/fast-sqlite-inserts/
 // sqlite3 options at https://github.com/mattn/go-sqlite3#connection-string
-const DBNAME string = "reconcile-go.db?_sync=0&_journal=WAL"
+const DBOPTIONS string = "?_sync=0&_journal=WAL"
+
+const DBNAME string = DBFILE + DBOPTIONS
+
+// BOLTFILE is the bolt store's file name.
+const BOLTFILE string = "reconcile-go.bolt"
+
+// LASTMODIFIEDLAYOUT is the time layout of the last_modified dump column, e.g.
+// 2020-12-22T19:20:44.396666. The fraction is optional.
+const LASTMODIFIEDLAYOUT string = "2006-01-02T15:04:05.999999999"
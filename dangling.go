@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DanglingKind is the type of broken link a Dangling describes.
+type DanglingKind string
+
+const (
+	DanglingMissingIAItem     DanglingKind = "missing_ia_item"    // An edition's ocaid isn't in the IA data.
+	DanglingMissingEdition    DanglingKind = "missing_edition"    // An IA item's openlibrary_edition isn't in the OL dump.
+	DanglingRedirectedEdition DanglingKind = "redirected_edition" // An IA item's openlibrary_edition is a redirect.
+	DanglingDeletedEdition    DanglingKind = "deleted_edition"    // An IA item's openlibrary_edition was deleted.
+)
+
+// Dangling is a link from OL to IA, or IA to OL, that goes nowhere.
+type Dangling struct {
+	kind     DanglingKind
+	olid     string
+	ocaid    string
+	resolved string // For DanglingRedirectedEdition, the OLID at the end of the redirects.
+}
+
+// missingIAItemQuery finds editions, last modified after ?1 unless it's
+// empty, whose ocaid isn't in the ia table.
+const missingIAItemQuery = `
+  SELECT ol.edition_id, ol.ocaid
+  FROM ol
+  LEFT JOIN ia ON ia.identifier = ol.ocaid
+  WHERE coalesce(ol.ocaid, '') != ''
+    AND ia.identifier IS NULL
+    AND (?1 = '' OR ol.last_modified > ?1)
+  ORDER BY ol.ocaid, ol.edition_id`
+
+// missingEditionQuery finds IA items whose openlibrary_edition isn't in the
+// ol table. There's no edition to have been modified, so there are none when
+// ?1 isn't empty.
+const missingEditionQuery = `
+  SELECT ia.ol_edition_id, ia.identifier
+  FROM ia
+  LEFT JOIN ol ON ol.edition_id = ia.ol_edition_id
+  WHERE coalesce(ia.ol_edition_id, '') != ''
+    AND ol.edition_id IS NULL
+    AND ?1 = ''
+  ORDER BY ia.identifier`
+
+// getDangling calls fn with each Dangling link in the DB: first editions with
+// missing IA items, then IA items with missing editions. It needs both an OL
+// and an IA load, or everything would dangle. Unless modifiedAfter is zero,
+// only editions last modified after it are included, which leaves out the
+// IA items whose edition is missing.
+func getDangling(db *sql.DB, modifiedAfter time.Time, fn func(d *Dangling) error) error {
+	after := modifiedAfterArg(modifiedAfter)
+
+	for _, table := range []string{"ol", "ia"} {
+		var loaded bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM " + table + ")").Scan(&loaded); err != nil {
+			return err
+		}
+		if !loaded {
+			return fmt.Errorf("%v is empty: %w", table, ErrorMissingLoad)
+		}
+	}
+
+	rows, err := db.Query(missingIAItemQuery, after)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := Dangling{kind: DanglingMissingIAItem}
+		if err := rows.Scan(&d.olid, &d.ocaid); err != nil {
+			return err
+		}
+
+		if err := fn(&d); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	resolver, err := getRedirectResolver(db)
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.Query(missingEditionQuery, after)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := Dangling{kind: DanglingMissingEdition}
+		if err := rows.Scan(&d.olid, &d.ocaid); err != nil {
+			return err
+		}
+
+		// A redirect loop has no edition at the end, so it's just missing.
+		resolved, err := resolver.resolve(d.olid)
+		switch {
+		case errors.Is(err, ErrorOlidDeleted):
+			d.kind = DanglingDeletedEdition
+		case err == nil && resolved != d.olid:
+			d.kind = DanglingRedirectedEdition
+			d.resolved = resolved
+		}
+
+		if err := fn(&d); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// runDangling writes the dangling links in an already loaded DB, narrowed to
+// editions last modified after modifiedAfter unless it's zero, to w.
+func runDangling(dbName string, modifiedAfter time.Time, w reportWriter) error {
+	db, err := getDB(dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := w.writeHeader([]string{"kind", "olid", "ocaid", "resolved"}); err != nil {
+		return err
+	}
+
+	return getDangling(db, modifiedAfter, func(d *Dangling) error {
+		return w.writeRow(string(d.kind), d.olid, d.ocaid, d.resolved)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetDangling(t *testing.T) {
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getAll := func(modifiedAfter time.Time) ([]Dangling, error) {
+		var res []Dangling
+		err := getDangling(db, modifiedAfter, func(d *Dangling) error {
+			res = append(res, *d)
+			return nil
+		})
+		return res, err
+	}
+
+	loadTestRecords(t, db, newOLWriter,
+		&OpenLibraryEdition{olid: "OL001M", ocaid: "IA001"},
+		// IA002 was darked or never existed, and so was IA007, but OL007M
+		// hasn't been modified recently.
+		&OpenLibraryEdition{olid: "OL002M", ocaid: "IA002", lastModified: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+		&OpenLibraryEdition{olid: "OL007M", ocaid: "IA007", lastModified: time.Date(2020, 12, 22, 19, 20, 44, 396666000, time.UTC)},
+		&OpenLibraryEdition{olid: "OL003M"},
+		&OpenLibraryRedirect{olid: "OL004M", location: "OL001M"},
+		&OpenLibraryDeletion{olid: "OL005M"},
+	)
+
+	// Without an IA load every ocaid would dangle.
+	if _, err := getAll(time.Time{}); !errors.Is(err, ErrorMissingLoad) {
+		t.Fatalf("expected %v, but got %v", ErrorMissingLoad, err)
+	}
+
+	loadTestRecords(t, db, newIAWriter,
+		NewIAItem("IA001", nil, "OL001M", "", nil),
+		NewIAItem("IA003", nil, "", "", nil),
+		NewIAItem("IA004", nil, "OL004M", "", nil),
+		NewIAItem("IA005", nil, "OL005M", "", nil),
+		NewIAItem("IA006", nil, "OL006M", "", nil),
+	)
+
+	resDangling, err := getAll(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expDangling := []Dangling{
+		{kind: DanglingMissingIAItem, olid: "OL002M", ocaid: "IA002"},
+		{kind: DanglingMissingIAItem, olid: "OL007M", ocaid: "IA007"},
+		{kind: DanglingRedirectedEdition, olid: "OL004M", ocaid: "IA004", resolved: "OL001M"},
+		{kind: DanglingDeletedEdition, olid: "OL005M", ocaid: "IA005"},
+		{kind: DanglingMissingEdition, olid: "OL006M", ocaid: "IA006"},
+	}
+
+	if !reflect.DeepEqual(expDangling, resDangling) {
+		t.Fatalf("expected %v, but got %v", expDangling, resDangling)
+	}
+
+	// Only editions modified since, so no IA items' missing editions.
+	resDangling, err = getAll(time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expDangling = []Dangling{{kind: DanglingMissingIAItem, olid: "OL002M", ocaid: "IA002"}}
+	if !reflect.DeepEqual(expDangling, resDangling) {
+		t.Fatalf("expected %v, but got %v", expDangling, resDangling)
+	}
+}
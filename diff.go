@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiffKind is how an edition changed between two loads.
+type DiffKind string
+
+const (
+	DiffAdded    DiffKind = "added"
+	DiffRemoved  DiffKind = "removed"
+	DiffModified DiffKind = "modified"
+)
+
+// OcaidChange is how an edition's ocaid changed between two loads. A removal
+// often means vandalism or a bad merge.
+type OcaidChange string
+
+const (
+	OcaidAdded   OcaidChange = "added"
+	OcaidRemoved OcaidChange = "removed" // Including removed editions that had an ocaid.
+	OcaidChanged OcaidChange = "changed"
+)
+
+// EditionDiff is an edition that differs between an old and a new load.
+type EditionDiff struct {
+	kind         DiffKind
+	olid         string
+	ocaidChange  OcaidChange // Blank if the ocaid didn't change.
+	oldOcaid     string
+	newOcaid     string
+	isbnsAdded   []string
+	isbnsRemoved []string
+	changed      []string // For DiffModified, the ol columns that differ.
+	oldRevision  sql.NullInt64
+	newRevision  sql.NullInt64
+}
+
+// diffColumns are the ol columns compared between loads, in the order
+// editionDiffQuery selects them.
+var diffColumns = []string{"ocaid", "title", "subtitle", "publishers", "publish_date", "number_of_pages", "languages", "source_records"}
+
+// editionDiffQuery finds the editions that were added, removed or changed
+// between the old and new loads, with ocaid removals first. It's formatted
+// with the queries for the old editions and their ISBNs, then the new, from
+// diffSelects. The ISBN lists are sorted in practice, but SQLite doesn't
+// promise group_concat's order, so getDiffs compares them as sets.
+const editionDiffQuery = `
+  WITH
+    old_isbn AS (
+      SELECT edition_id, group_concat(isbn_13) AS isbns
+      FROM (SELECT DISTINCT edition_id, isbn_13 FROM (%[2]s) ORDER BY edition_id, isbn_13)
+      GROUP BY edition_id),
+    new_isbn AS (
+      SELECT edition_id, group_concat(isbn_13) AS isbns
+      FROM (SELECT DISTINCT edition_id, isbn_13 FROM (%[4]s) ORDER BY edition_id, isbn_13)
+      GROUP BY edition_id),
+    o AS (SELECT e.*, i.isbns FROM (%[1]s) e LEFT JOIN old_isbn i ON i.edition_id = e.edition_id),
+    n AS (SELECT e.*, i.isbns FROM (%[3]s) e LEFT JOIN new_isbn i ON i.edition_id = e.edition_id)
+  SELECT o.edition_id, n.edition_id, o.revision, n.revision, o.isbns, n.isbns,
+    o.ocaid, n.ocaid, o.title, n.title, o.subtitle, n.subtitle, o.publishers, n.publishers,
+    o.publish_date, n.publish_date, o.number_of_pages, n.number_of_pages,
+    o.languages, n.languages, o.source_records, n.source_records
+  FROM o FULL JOIN n ON n.edition_id = o.edition_id
+  WHERE o.edition_id IS NULL OR n.edition_id IS NULL
+    OR o.revision IS NOT n.revision OR o.isbns IS NOT n.isbns
+    OR o.ocaid IS NOT n.ocaid OR o.title IS NOT n.title OR o.subtitle IS NOT n.subtitle
+    OR o.publishers IS NOT n.publishers OR o.publish_date IS NOT n.publish_date
+    OR o.number_of_pages IS NOT n.number_of_pages OR o.languages IS NOT n.languages
+    OR o.source_records IS NOT n.source_records
+  ORDER BY coalesce(o.ocaid, '') != '' AND coalesce(n.ocaid, '') = '' DESC,
+    coalesce(o.edition_id, n.edition_id)`
+
+// diffLoad is one side of a diff: a DB file, and the ID of the OL run in it to
+// compare, or 0 for its latest snapshot.
+type diffLoad struct {
+	dbFile string
+	runID  int64
+}
+
+// diffSelects returns the queries for the editions of runID, or of the
+// latest snapshot if it's 0, in the DB attached as schema, and for their
+// ISBNs. The DB is only read, so a table or column it lacks, having been made
+// by an earlier version, is selected as empty or NULL rather than added.
+func diffSelects(ctx context.Context, conn *sql.Conn, schema string, runID int64) (editions, isbns string, err error) {
+	if runID != 0 {
+		if err := checkDiffRun(ctx, conn, schema, runID); err != nil {
+			return "", "", err
+		}
+
+		editions = fmt.Sprintf("SELECT edition_id, revision, %s FROM %s.ol_all WHERE run_id = %d",
+			strings.Join(diffColumns, ", "), schema, runID)
+		isbns = fmt.Sprintf("SELECT edition_id, isbn_13 FROM %s.edition_isbn_all WHERE run_id = %d", schema, runID)
+		return editions, isbns, nil
+	}
+
+	olColumns, err := tableColumns(ctx, conn, schema, "ol")
+	if err != nil {
+		return "", "", err
+	}
+
+	var selects []string
+	for _, column := range append([]string{"edition_id", "revision"}, diffColumns...) {
+		if olColumns[column] {
+			selects = append(selects, column)
+		} else {
+			selects = append(selects, "NULL AS "+column)
+		}
+	}
+	editions = "SELECT " + strings.Join(selects, ", ") + " FROM " + schema + ".ol"
+	if !olColumns["edition_id"] {
+		editions = "SELECT " + strings.Join(selects, ", ") + " WHERE 0"
+	}
+
+	isbnColumns, err := tableColumns(ctx, conn, schema, "edition_isbn")
+	if err != nil {
+		return "", "", err
+	}
+	isbns = "SELECT edition_id, isbn_13 FROM " + schema + ".edition_isbn"
+	if !isbnColumns["edition_id"] || !isbnColumns["isbn_13"] {
+		isbns = "SELECT NULL AS edition_id, NULL AS isbn_13 WHERE 0"
+	}
+
+	return editions, isbns, nil
+}
+
+// checkDiffRun returns ErrorUnknownRun unless runID is a complete OL run, so
+// still has its rows, in the DB attached as schema.
+func checkDiffRun(ctx context.Context, conn *sql.Conn, schema string, runID int64) error {
+	columns, err := tableColumns(ctx, conn, schema, "run")
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("run %d: %w", runID, ErrorUnknownRun)
+	}
+
+	var kind, status string
+	err = conn.QueryRowContext(ctx, "SELECT kind, status FROM "+schema+".run WHERE id = ?", runID).Scan(&kind, &status)
+	if errors.Is(err, sql.ErrNoRows) || kind != "ol" || status != string(RunComplete) {
+		return fmt.Errorf("run %d: %w", runID, ErrorUnknownRun)
+	}
+
+	return err
+}
+
+// tableColumns returns the columns of table, or view, in the DB attached as
+// schema. A missing table has none.
+func tableColumns(ctx context.Context, conn *sql.Conn, schema, table string) (map[string]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM pragma_table_info(?, ?)", table, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
+
+// readOnlyURI returns a URI to open or attach the SQLite DB at path with, so
+// it can't be written. The path is made absolute, as a URI's must be.
+func readOnlyURI(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	return (&url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}).String(), nil
+}
+
+// getDiffs calls fn with each edition that differs between the old and new
+// loads. Both DBs are attached read-only to db, even if they're the same one.
+func getDiffs(db *sql.DB, oldLoad, newLoad diffLoad, fn func(d *EditionDiff) error) error {
+	// ATTACH only applies to one connection, so pin one.
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var selects []interface{}
+	for _, load := range []struct {
+		schema string
+		diffLoad
+	}{{"old", oldLoad}, {"new", newLoad}} {
+		uri, err := readOnlyURI(load.dbFile)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+load.schema, uri); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "DETACH DATABASE "+load.schema)
+
+		editions, isbns, err := diffSelects(ctx, conn, load.schema, load.runID)
+		if err != nil {
+			return err
+		}
+		selects = append(selects, editions, isbns)
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(editionDiffQuery, selects...))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var oldOlid, newOlid, oldIsbns, newIsbns sql.NullString
+		var d EditionDiff
+		oldValues := make([]sql.NullString, len(diffColumns))
+		newValues := make([]sql.NullString, len(diffColumns))
+		dest := []interface{}{&oldOlid, &newOlid, &d.oldRevision, &d.newRevision, &oldIsbns, &newIsbns}
+		for i := range diffColumns {
+			dest = append(dest, &oldValues[i], &newValues[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		switch {
+		case !oldOlid.Valid:
+			d.kind, d.olid = DiffAdded, newOlid.String
+		case !newOlid.Valid:
+			d.kind, d.olid = DiffRemoved, oldOlid.String
+		default:
+			d.kind, d.olid = DiffModified, newOlid.String
+		}
+
+		// A NULL and a blank value are the same to a librarian.
+		d.oldOcaid, d.newOcaid = oldValues[0].String, newValues[0].String
+		switch {
+		case d.oldOcaid == d.newOcaid:
+		case d.oldOcaid == "":
+			d.ocaidChange = OcaidAdded
+		case d.newOcaid == "":
+			d.ocaidChange = OcaidRemoved
+		default:
+			d.ocaidChange = OcaidChanged
+		}
+
+		oldIsbnList, newIsbnList := splitStored(oldIsbns.String, ","), splitStored(newIsbns.String, ",")
+		d.isbnsAdded = difference(newIsbnList, oldIsbnList)
+		d.isbnsRemoved = difference(oldIsbnList, newIsbnList)
+
+		if d.kind == DiffModified {
+			for i, column := range diffColumns {
+				if oldValues[i].String != newValues[i].String {
+					d.changed = append(d.changed, column)
+				}
+			}
+			if len(d.isbnsAdded) > 0 || len(d.isbnsRemoved) > 0 {
+				d.changed = append(d.changed, "isbn_13")
+			}
+
+			// Only the order of the ISBNs, or NULL vs blank, differed.
+			if len(d.changed) == 0 && d.oldRevision == d.newRevision {
+				continue
+			}
+		}
+
+		if err := fn(&d); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// difference returns the sorted values in a that aren't in b.
+func difference(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, v := range b {
+		in[v] = true
+	}
+
+	var diff []string
+	for _, v := range a {
+		if !in[v] {
+			diff = append(diff, v)
+		}
+	}
+	sort.Strings(diff)
+
+	return diff
+}
+
+// isSQLiteFile reports whether the file at path is a SQLite DB rather than a
+// dump, going by its header.
+func isSQLiteFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(f, header); err != nil {
+		// Too short to be a DB.
+		return false, nil
+	}
+
+	return bytes.Equal(header, []byte("SQLite format 3\x00")), nil
+}
+
+// getDiffDB returns the path of a DB holding the load in path, which is
+// either an earlier load's DB, or an OL dump that's loaded into a new DB in
+// tmpDir. Dumps are loaded without -modified-after, so they're complete. A
+// dump has no runs, so runID must be 0 for one.
+func getDiffDB(ctx context.Context, path string, runID int64, tmpDir, name string, out io.Writer) (string, error) {
+	if path != "-" {
+		isDB, err := isSQLiteFile(path)
+		if err != nil {
+			return "", err
+		}
+		if isDB {
+			return path, nil
+		}
+	}
+
+	if runID != 0 {
+		return "", fmt.Errorf("run %d in dump %v: %w", runID, path, ErrorUnknownRun)
+	}
+
+	dbFile := filepath.Join(tmpDir, name+".db")
+	store, err := newSQLiteStore(dbFile + DBOPTIONS)
+	if err != nil {
+		return "", err
+	}
+	defer store.close()
+
+	if err := loadDump(ctx, store, "ol", path, NewOpenLibraryParser(editionFields, time.Time{}), out); err != nil {
+		return "", err
+	}
+
+	return dbFile, nil
+}
+
+// runDiff writes the editions that differ between the old and new loads to out
+// in format. Each is a DB from an earlier load or an OL dump file. In a DB,
+// oldRun and newRun pick an OL run to compare rather than its latest snapshot,
+// and with oldRun, oldLoad defaults to newLoad, to compare two runs in one DB.
+// Progress and errors from loading dumps go to progress.
+func runDiff(ctx context.Context, oldLoad, newLoad string, oldRun, newRun int64, format string, out, progress io.Writer) error {
+	if oldLoad == "" && oldRun != 0 {
+		oldLoad = newLoad
+	}
+
+	w, err := newReportWriter(format, "reconcile-go diff", out)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "reconcile-go-diff")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldDBFile, err := getDiffDB(ctx, oldLoad, oldRun, tmpDir, "old", progress)
+	if err != nil {
+		return err
+	}
+
+	newDBFile, err := getDiffDB(ctx, newLoad, newRun, tmpDir, "new", progress)
+	if err != nil {
+		return err
+	}
+
+	// The loads are attached to an empty DB, so neither is migrated or
+	// otherwise changed.
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	columns := []string{"change", "olid", "ocaid_change", "old_ocaid", "new_ocaid", "isbns_added", "isbns_removed", "changed", "old_revision", "new_revision"}
+	if err := w.writeHeader(columns); err != nil {
+		return err
+	}
+
+	err = getDiffs(db, diffLoad{oldDBFile, oldRun}, diffLoad{newDBFile, newRun}, func(d *EditionDiff) error {
+		return w.writeRow(string(d.kind), d.olid, string(d.ocaidChange), d.oldOcaid, d.newOcaid,
+			d.isbnsAdded, d.isbnsRemoved, d.changed, revisionValue(d.oldRevision), revisionValue(d.newRevision))
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.close()
+}
+
+// revisionValue returns a revision for a report, or nil if it's not valid.
+func revisionValue(revision sql.NullInt64) interface{} {
+	if !revision.Valid {
+		return nil
+	}
+	return revision.Int64
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDiff(t *testing.T) {
+	dir := t.TempDir()
+
+	// The old load is a dump, and the new load a DB.
+	oldDump := filepath.Join(dir, "old.txt")
+	lines := `/type/edition	/books/OL001M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL001M", "isbn_13": ["9788955565683"], "ocaid": "IA001"}
+/type/edition	/books/OL002M	3	2020-12-22T19:20:44.396666	{"key": "/books/OL002M", "ocaid": "IA002"}
+/type/edition	/books/OL003M	2	2020-12-22T19:20:44.396666	{"key": "/books/OL003M", "isbn_13": ["9780141439518"]}
+/type/edition	/books/OL004M	1	2020-12-22T19:20:44.396666	{"key": "/books/OL004M"}
+`
+	if err := os.WriteFile(oldDump, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	newDB := filepath.Join(dir, "new.db")
+	db, err := getDB(newDB + DBOPTIONS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadTestRecords(t, db, newOLWriter,
+		&OpenLibraryEdition{olid: "OL001M", isbns: []string{"9788955565683"}, ocaid: "IA001", revision: 6},
+		&OpenLibraryEdition{olid: "OL002M", revision: 4},
+		&OpenLibraryEdition{olid: "OL003M", isbns: []string{"9780141439518", "9780135043943"}, revision: 3},
+		&OpenLibraryEdition{olid: "OL005M", ocaid: "IA005", revision: 1},
+	)
+	db.Close()
+
+	// A DB from the first release, with only the ol table and no revisions.
+	baselineDB := filepath.Join(dir, "baseline.db")
+	db, err = sql.Open("sqlite3", baselineDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE ol (id INTEGER NOT NULL PRIMARY KEY, edition_id text, ocaid text, isbn_13 text);
+	  INSERT INTO ol (edition_id, ocaid) VALUES ('OL001M', 'IA001'), ('OL002M', 'IA002'), ('OL006M', NULL);`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// A DB with two runs, to compare with -old-run and -new-run.
+	runsDB := filepath.Join(dir, "runs.db")
+	db, err = getDB(runsDB + DBOPTIONS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadTestRecords(t, db, newOLWriter,
+		&OpenLibraryEdition{olid: "OL001M", ocaid: "IA001", revision: 1},
+		&OpenLibraryEdition{olid: "OL002M", revision: 1},
+	)
+	loadTestRecords(t, db, newOLWriter,
+		&OpenLibraryEdition{olid: "OL001M", revision: 2},
+		&OpenLibraryEdition{olid: "OL003M", revision: 1},
+	)
+	db.Close()
+
+	tests := []struct {
+		name           string
+		old, new       string
+		oldRun, newRun int64
+		exp            string
+	}{
+		{
+			// The ocaid removal comes first.
+			name: "DumpToDB", old: oldDump, new: newDB,
+			exp: "change\tolid\tocaid_change\told_ocaid\tnew_ocaid\tisbns_added\tisbns_removed\tchanged\told_revision\tnew_revision\n" +
+				"modified\tOL002M\tremoved\tIA002\t\t\t\tocaid\t3\t4\n" +
+				"modified\tOL003M\t\t\t\t9780135043943\t\tisbn_13\t2\t3\n" +
+				"removed\tOL004M\t\t\t\t\t\t\t1\t\n" +
+				"added\tOL005M\tadded\t\tIA005\t\t\t\t\t1\n",
+		},
+		{
+			// The missing edition_isbn table and revision column are read as
+			// empty, rather than the DB being migrated.
+			name: "BaselineDBToDB", old: baselineDB, new: newDB,
+			exp: "change\tolid\tocaid_change\told_ocaid\tnew_ocaid\tisbns_added\tisbns_removed\tchanged\told_revision\tnew_revision\n" +
+				"modified\tOL002M\tremoved\tIA002\t\t\t\tocaid\t\t4\n" +
+				"modified\tOL001M\t\tIA001\tIA001\t9788955565683\t\tisbn_13\t\t6\n" +
+				"added\tOL003M\t\t\t\t9780135043943;9780141439518\t\t\t\t3\n" +
+				"added\tOL005M\tadded\t\tIA005\t\t\t\t\t1\n" +
+				"removed\tOL006M\t\t\t\t\t\t\t\t\n",
+		},
+		{
+			// With no -old, -old-run is in the -new DB, compared with its
+			// latest run.
+			name: "RunToLatest", new: runsDB, oldRun: 1,
+			exp: "change\tolid\tocaid_change\told_ocaid\tnew_ocaid\tisbns_added\tisbns_removed\tchanged\told_revision\tnew_revision\n" +
+				"modified\tOL001M\tremoved\tIA001\t\t\t\tocaid\t1\t2\n" +
+				"removed\tOL002M\t\t\t\t\t\t\t1\t\n" +
+				"added\tOL003M\t\t\t\t\t\t\t\t1\n",
+		},
+		{
+			name: "RunToRun", old: runsDB, new: runsDB, oldRun: 2, newRun: 1,
+			exp: "change\tolid\tocaid_change\told_ocaid\tnew_ocaid\tisbns_added\tisbns_removed\tchanged\told_revision\tnew_revision\n" +
+				"modified\tOL001M\tadded\t\tIA001\t\t\tocaid\t2\t1\n" +
+				"added\tOL002M\t\t\t\t\t\t\t\t1\n" +
+				"removed\tOL003M\t\t\t\t\t\t\t1\t\n",
+		},
+		{
+			name: "Unchanged", old: newDB, new: newDB,
+			exp: "change\tolid\tocaid_change\told_ocaid\tnew_ocaid\tisbns_added\tisbns_removed\tchanged\told_revision\tnew_revision\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := runDiff(context.Background(), tc.old, tc.new, tc.oldRun, tc.newRun, "tsv", &out, io.Discard); err != nil {
+				t.Fatal(err)
+			}
+
+			if out.String() != tc.exp {
+				t.Fatalf("expected %q, but got %q", tc.exp, out.String())
+			}
+		})
+	}
+
+	// A run must be a complete OL run in a DB.
+	for _, tc := range []struct {
+		name           string
+		old            string
+		oldRun, newRun int64
+	}{
+		{name: "UnknownRun", old: runsDB, oldRun: 3},
+		{name: "NoRunTable", old: baselineDB, oldRun: 1},
+		{name: "DumpRun", old: oldDump, oldRun: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := runDiff(context.Background(), tc.old, runsDB, tc.oldRun, tc.newRun, "tsv", io.Discard, io.Discard)
+			if !errors.Is(err, ErrorUnknownRun) {
+				t.Fatalf("expected %v, but got %v", ErrorUnknownRun, err)
+			}
+		})
+	}
+
+	// Diffing against it left the baseline DB as it was.
+	db, err = sql.Open("sqlite3", baselineDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var tables int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 1 {
+		t.Fatalf("expected the baseline DB to only have the ol table, but it has %d", tables)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// DuplicateGroup is a set of editions that share an ISBN, LCCN or OCLC number,
+// and so may be duplicates that should be merged.
+type DuplicateGroup struct {
+	shared   []string // The shared identifiers, e.g. isbn_13:9780141439518.
+	editions []duplicateEdition
+	score    float64 // How alike the editions are, from 0 to 1.
+}
+
+// duplicateEdition is the metadata of an edition in a DuplicateGroup.
+type duplicateEdition struct {
+	olid        string
+	title       string
+	subtitle    string
+	publishers  string
+	publishDate string
+	pages       int
+	languages   string
+}
+
+// sharedIdentifiersQuery gets the editions sharing each ISBN, LCCN or OCLC
+// number that's on more than one edition, ordered by identifier.
+const sharedIdentifiersQuery = `
+  WITH
+    shared AS (
+      SELECT 'isbn_13' AS name, isbn_13 AS value, edition_id FROM edition_isbn
+      UNION
+      SELECT name, value, edition_id FROM edition_identifier WHERE name IN ('lccn', 'oclc')),
+    dup AS (
+      SELECT name, value FROM shared
+      GROUP BY name, value
+      HAVING count(DISTINCT edition_id) > 1)
+  SELECT s.name, s.value, ol.edition_id, coalesce(ol.title, ''), coalesce(ol.subtitle, ''),
+    coalesce(ol.publishers, ''), coalesce(ol.publish_date, ''), coalesce(ol.number_of_pages, 0),
+    coalesce(ol.languages, '')
+  FROM dup d
+  JOIN shared s ON s.name = d.name AND s.value = d.value
+  JOIN ol ON ol.edition_id = s.edition_id
+  ORDER BY s.name, s.value, ol.edition_id`
+
+// duplicateTables hold the groups getDuplicateGroups finds while it reads
+// sharedIdentifiersQuery, so SQLite ranks them rather than memory. They're
+// temp tables, which only the transaction's connection sees.
+const duplicateTables = `
+  CREATE TEMP TABLE duplicate_group (
+    id INTEGER NOT NULL PRIMARY KEY, olids text NOT NULL UNIQUE, shared text, identifiers integer, score real);
+  CREATE TEMP TABLE duplicate_edition (
+    group_id integer, position integer, edition_id text, title text, subtitle text, publishers text,
+    publish_date text, number_of_pages integer, languages text);`
+
+// rankedDuplicatesQuery gets the editions of each duplicate_group, ranked by
+// score, then by how many identifiers they share, then in the order the
+// groups were found.
+const rankedDuplicatesQuery = `
+  SELECT g.id, g.shared, g.score, e.edition_id, e.title, e.subtitle, e.publishers, e.publish_date,
+    e.number_of_pages, e.languages
+  FROM temp.duplicate_group g
+  JOIN temp.duplicate_edition e ON e.group_id = g.id
+  ORDER BY g.score DESC, g.identifiers DESC, g.id, e.position`
+
+// getDuplicateGroups calls fn with each group of editions sharing an
+// identifier, most likely duplicates first. Editions that share several
+// identifiers are one group. Groups are ranked by score, then by how many
+// identifiers they share, so they're scored into temp tables and read back
+// in that order, rather than held in memory.
+func getDuplicateGroups(db *sql.DB, fn func(g *DuplicateGroup) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Rolling back drops the temp tables.
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(duplicateTables); err != nil {
+		return err
+	}
+	if err := storeDuplicateGroups(tx); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(rankedDuplicatesQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var g *DuplicateGroup
+	var groupID int64
+	for rows.Next() {
+		var id int64
+		var shared string
+		var score float64
+		var e duplicateEdition
+		if err := rows.Scan(&id, &shared, &score, &e.olid, &e.title, &e.subtitle, &e.publishers, &e.publishDate, &e.pages, &e.languages); err != nil {
+			return err
+		}
+
+		if g == nil || id != groupID {
+			if g != nil {
+				if err := fn(g); err != nil {
+					return err
+				}
+			}
+			g, groupID = &DuplicateGroup{shared: strings.Split(shared, ";"), score: score}, id
+		}
+		g.editions = append(g.editions, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if g != nil {
+		return fn(g)
+	}
+	return nil
+}
+
+// storeDuplicateGroups reads sharedIdentifiersQuery and stores each group of
+// editions sharing an identifier, with its score, in the temp tables. A group
+// whose editions already share another identifier gets it added instead.
+func storeDuplicateGroups(tx *sql.Tx) error {
+	rows, err := tx.Query(sharedIdentifiersQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	addGroup := func(identifier string, editions []duplicateEdition) error {
+		if len(editions) < 2 {
+			return nil
+		}
+
+		olids := make([]string, len(editions))
+		for i, e := range editions {
+			olids[i] = e.olid
+		}
+		key := strings.Join(olids, ",")
+
+		res, err := tx.Exec(
+			"UPDATE temp.duplicate_group SET shared = shared || ';' || ?, identifiers = identifiers + 1 WHERE olids = ?",
+			identifier, key,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+
+		res, err = tx.Exec(
+			"INSERT INTO temp.duplicate_group (olids, shared, identifiers, score) VALUES (?, ?, 1, ?)",
+			key, identifier, scoreDuplicates(editions),
+		)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for i, e := range editions {
+			if _, err := tx.Exec(
+				"INSERT INTO temp.duplicate_edition VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				id, i, e.olid, e.title, e.subtitle, e.publishers, e.publishDate, e.pages, e.languages,
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var identifier string
+	var editions []duplicateEdition
+	for rows.Next() {
+		var name, value string
+		var e duplicateEdition
+		if err := rows.Scan(&name, &value, &e.olid, &e.title, &e.subtitle, &e.publishers, &e.publishDate, &e.pages, &e.languages); err != nil {
+			return err
+		}
+
+		if name+":"+value != identifier {
+			if err := addGroup(identifier, editions); err != nil {
+				return err
+			}
+			identifier, editions = name+":"+value, nil
+		}
+
+		// An edition can be in ol more than once, if it was loaded twice.
+		if len(editions) > 0 && editions[len(editions)-1].olid == e.olid {
+			continue
+		}
+		editions = append(editions, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return addGroup(identifier, editions)
+}
+
+// scoreDuplicates is the mean of how alike each pair of editions is, scored
+// the way an edition and an IA item are, both ways round, taking the better.
+func scoreDuplicates(editions []duplicateEdition) float64 {
+	var sum float64
+	var pairs int
+	for i := range editions {
+		for j := i + 1; j < len(editions); j++ {
+			a, b := &editions[i], &editions[j]
+			sum += max(scoreMatch(duplicateMetadata(a, b)).total, scoreMatch(duplicateMetadata(b, a)).total)
+			pairs++
+		}
+	}
+
+	if pairs == 0 {
+		return 0
+	}
+	return sum / float64(pairs)
+}
+
+// duplicateMetadata returns the matchMetadata for comparing edition a with b,
+// with b standing in for the IA item.
+func duplicateMetadata(a, b *duplicateEdition) *matchMetadata {
+	bTitle := b.title
+	if b.subtitle != "" {
+		bTitle += " " + b.subtitle
+	}
+
+	return &matchMetadata{
+		olTitle: a.title, olSubtitle: a.subtitle, olPublishDate: a.publishDate, olPublishers: a.publishers,
+		olPages: a.pages, olLanguages: a.languages,
+		iaTitle: bTitle, iaDate: b.publishDate, iaPublishers: b.publishers, iaImageCount: b.pages, iaLanguages: b.languages,
+	}
+}
+
+// runDuplicates writes the groups of editions sharing an identifier in an
+// already loaded DB to w, most likely duplicates first.
+func runDuplicates(dbName string, w reportWriter) error {
+	db, err := getDB(dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := w.writeHeader([]string{"score", "shared", "olids", "titles", "publishers", "publish_dates"}); err != nil {
+		return err
+	}
+
+	return getDuplicateGroups(db, func(g *DuplicateGroup) error {
+		var olids, titles, publishers, publishDates []string
+		for _, e := range g.editions {
+			olids = append(olids, e.olid)
+			titles = append(titles, e.title)
+			// Each edition's publishers are ; separated too.
+			publishers = append(publishers, strings.ReplaceAll(e.publishers, ";", ", "))
+			publishDates = append(publishDates, e.publishDate)
+		}
+
+		return w.writeRow(scoreValue(validSignal(g.score)), g.shared, olids, titles, publishers, publishDates)
+	})
+}
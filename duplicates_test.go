@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetDuplicateGroups(t *testing.T) {
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	loadTestRecords(t, db, newOLWriter,
+		// Unlike editions sharing an OCLC number.
+		&OpenLibraryEdition{olid: "OL001M", title: "Seals", oclcNumbers: []string{"12345"}},
+		&OpenLibraryEdition{olid: "OL002M", title: "Whales of the World", oclcNumbers: []string{"(OCoLC)ocm00012345"}},
+		// Alike editions sharing an ISBN and an LCCN, which are one group.
+		&OpenLibraryEdition{
+			olid: "OL003M", title: "Jane Eyre", publishers: []string{"Penguin"}, publishDate: "2006",
+			isbns: []string{"9780141439518"}, lccns: []string{"2001012345"},
+		},
+		&OpenLibraryEdition{
+			olid: "OL004M", title: "Jane Eyre", publishers: []string{"Penguin"}, publishDate: "2006",
+			isbns: []string{"9780141439518"}, lccns: []string{"2001-12345"},
+		},
+		// Nothing shared.
+		&OpenLibraryEdition{olid: "OL005M", title: "Jane Eyre", isbns: []string{"9780135043943"}},
+	)
+
+	var groups []DuplicateGroup
+	if err := getDuplicateGroups(db, func(g *DuplicateGroup) error {
+		groups = append(groups, *g)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var resShared [][]string
+	var resOlids [][]string
+	var resScores []float64
+	for _, g := range groups {
+		var olids []string
+		for _, e := range g.editions {
+			olids = append(olids, e.olid)
+		}
+		resShared = append(resShared, g.shared)
+		resOlids = append(resOlids, olids)
+		resScores = append(resScores, g.score)
+	}
+
+	expShared := [][]string{{"isbn_13:9780141439518", "lccn:2001012345"}, {"oclc:12345"}}
+	expOlids := [][]string{{"OL003M", "OL004M"}, {"OL001M", "OL002M"}}
+	// Title, year and publisher agree, but there are no pages or languages.
+	expScores := []float64{0.75, 0}
+
+	if !reflect.DeepEqual(expShared, resShared) {
+		t.Fatalf("expected shared %v, but got %v", expShared, resShared)
+	}
+	if !reflect.DeepEqual(expOlids, resOlids) {
+		t.Fatalf("expected OLIDs %v, but got %v", expOlids, resOlids)
+	}
+	if !reflect.DeepEqual(expScores, resScores) {
+		t.Fatalf("expected scores %v, but got %v", expScores, resScores)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// optionalEditionFields are the edition fields, named as in the Open Library
+// JSON, that are only parsed when selected. Everything needed for ISBN
+// linking is always parsed.
+var optionalEditionFields = []string{
+	"title",
+	"subtitle",
+	"publishers",
+	"publish_date",
+	"number_of_pages",
+	"languages",
+	"lccn",
+	"oclc_numbers",
+	"source_records",
+	"identifiers",
+}
+
+// EditionFields is the set of optional edition fields to parse.
+type EditionFields map[string]bool
+
+// editionFields is the set of optional fields runSeek parses. It's set from
+// -fields and defaults to all of them.
+var editionFields = allEditionFields()
+
+func allEditionFields() EditionFields {
+	fields := EditionFields{}
+	for _, field := range optionalEditionFields {
+		fields[field] = true
+	}
+	return fields
+}
+
+// parseEditionFields reads a comma separated list of optional edition fields,
+// such as "title,publishers". "all" selects every field and "none", or an
+// empty string, selects none.
+func parseEditionFields(s string) (EditionFields, error) {
+	switch strings.TrimSpace(s) {
+	case "all":
+		return allEditionFields(), nil
+	case "", "none":
+		return EditionFields{}, nil
+	}
+
+	known := allEditionFields()
+	fields := EditionFields{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if !known[field] {
+			return nil, fmt.Errorf("%v: %w", field, ErrorUnknownField)
+		}
+		fields[field] = true
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseEditionFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		expFields EditionFields
+		expErr    error
+	}{
+		{name: "All", input: "all", expFields: allEditionFields()},
+		{name: "None", input: "none", expFields: EditionFields{}},
+		{name: "Empty", input: "", expFields: EditionFields{}},
+		{name: "List", input: "title, publishers", expFields: EditionFields{"title": true, "publishers": true}},
+		{name: "Unknown", input: "title,colour", expErr: ErrorUnknownField},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fields, err := parseEditionFields(tc.input)
+			if !errors.Is(err, tc.expErr) {
+				t.Fatalf("expected %v, but got %v", tc.expErr, err)
+			}
+
+			if !reflect.DeepEqual(tc.expFields, fields) {
+				t.Fatalf("expected %v, but got %v", tc.expFields, fields)
+			}
+		})
+	}
+}
+
+// TestSelectedEditionFields verifies only the selected optional fields are
+// parsed.
+func TestSelectedEditionFields(t *testing.T) {
+	line := `/type/edition	/books/OL001M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL001M", "title": "Jane Eyre", "subtitle": "An Autobiography", "lccn": ["2001012345"], "number_of_pages": 532}`
+	record, err := parseOLLine([]byte(line), EditionFields{"title": true, "lccn": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := &OpenLibraryEdition{olid: "OL001M", revision: 6, lastModified: testLastModified, title: "Jane Eyre", lccns: []string{"2001012345"}}
+	if !reflect.DeepEqual(exp, record) {
+		t.Fatalf("expected %v, but got %v", exp, record)
+	}
+}
@@ -3,7 +3,29 @@ package main
 import "errors"
 
 var (
-	ErrorWrongColCount   = errors.New("invalid number of columns")
-	ErrorNotEdition      = errors.New("line is not an edition")
-	ErrorNewlineNotFound = errors.New("newline not found")
+	ErrorWrongColCount     = errors.New("invalid number of columns")
+	ErrorUnsupportedType   = errors.New("unsupported record type")
+	ErrorSkipLine          = errors.New("line skipped")
+	ErrorUnsupportedRecord = errors.New("record not supported by this writer")
+	ErrorNewlineNotFound   = errors.New("newline not found")
+	ErrorWrongIsbnLength   = errors.New("ISBN has the wrong length")
+	ErrorIsbnNotNumeric    = errors.New("ISBN contains non-numeric characters")
+	ErrorNoIsbn10          = errors.New("ISBN 13 has no ISBN 10 equivalent")
+	ErrorNoIdentifier      = errors.New("item has no identifier")
+	ErrorRedirectLoop      = errors.New("redirect chain loops")
+	ErrorOlidDeleted       = errors.New("OLID is deleted")
+	ErrorUnknownField      = errors.New("unknown edition field")
+	ErrorInvalidMARC       = errors.New("invalid MARC record")
+	ErrorBadRevision       = errors.New("invalid revision or last_modified")
+	ErrorMissingLoad       = errors.New("report needs both an OL and an IA load")
+	ErrorUnknownFormat     = errors.New("unknown report format")
+	ErrorUnknownReport     = errors.New("unknown report")
+	ErrorBadBatchSize      = errors.New("batch size must be at least 1")
+	ErrorUnknownStore      = errors.New("unknown store")
+	ErrorNeedsSQLite       = errors.New("report needs the sqlite store")
+	ErrorNotFound          = errors.New("not found in store")
+	ErrorWrongRunKind      = errors.New("record is the wrong kind for the run")
+	ErrorNoMinScore        = errors.New("export needs a -min-score above 0")
+	ErrorUnknownRun        = errors.New("no complete OL run with that ID")
+	ErrorNoRuns            = errors.New("the bolt store doesn't keep runs")
 )
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// editPayload is an Open Library edit setting an edition's ocaid.
+type editPayload struct {
+	Key     string `json:"key"` // E.g. /books/OL1M.
+	Ocaid   string `json:"ocaid"`
+	Comment string `json:"comment"`
+}
+
+// acceptedLinksQuery gets the link candidates scoring at least ?1, and whether
+// each is still current: the edition is in the latest snapshot with no ocaid,
+// and the IA item is too, unlinked and not any edition's ocaid. A reload since
+// the reconcile may have linked either side. A candidate whose OLID or ocaid
+// appears in another accepted candidate is ambiguous, so it's left out.
+const acceptedLinksQuery = `
+  SELECT lc.olid, lc.ocaid, lc.reason, lc.score,
+    EXISTS (SELECT 1 FROM ol WHERE ol.edition_id = lc.olid)
+      AND NOT EXISTS (SELECT 1 FROM ol WHERE ol.edition_id = lc.olid AND coalesce(ol.ocaid, '') != '')
+      AND NOT EXISTS (SELECT 1 FROM ol WHERE ol.ocaid = lc.ocaid)
+      AND EXISTS (SELECT 1 FROM ia WHERE ia.identifier = lc.ocaid)
+      AND NOT EXISTS (SELECT 1 FROM ia WHERE ia.identifier = lc.ocaid AND coalesce(ia.ol_edition_id, '') != '')
+  FROM link_candidate lc
+  WHERE lc.score >= ?1
+    AND lc.olid IN (SELECT olid FROM link_candidate WHERE score >= ?1 GROUP BY olid HAVING count(*) = 1)
+    AND lc.ocaid IN (SELECT ocaid FROM link_candidate WHERE score >= ?1 GROUP BY ocaid HAVING count(*) = 1)
+  ORDER BY lc.olid`
+
+// exportStats counts what exportEdits wrote.
+type exportStats struct {
+	edits     int
+	batches   int
+	ambiguous int // Accepted candidates left out because they share an OLID or ocaid.
+	stale     int // Accepted candidates left out because either side was linked since.
+}
+
+// removeExport deletes the batch files and rollback.json of an earlier export
+// from dir, so a batch it wrote past the end of this one isn't applied again.
+// Other files are left alone.
+func removeExport(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "edits-*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range append(files, filepath.Join(dir, "rollback.json")) {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportEdits writes the link candidates scoring at least minScore, from the
+// last reconcile, as Open Library edit payloads to batch files of batchSize
+// edits in dir: edits-00001.json and so on, each a JSON array, replacing any
+// from an earlier export. Only candidates
+// still unlinked in the latest loads are written, so rollback.json gets the
+// edits that clear each edition's ocaid again. minScore must be above 0, so
+// there's no default and candidates with no supporting metadata are never
+// exported.
+func exportEdits(db *sql.DB, dir string, batchSize int, minScore float64, comment string) (exportStats, error) {
+	var stats exportStats
+	if batchSize < 1 {
+		return stats, fmt.Errorf("batch size %d: %w", batchSize, ErrorBadBatchSize)
+	}
+	if minScore <= 0 {
+		return stats, ErrorNoMinScore
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return stats, err
+	}
+	if err := removeExport(dir); err != nil {
+		return stats, err
+	}
+
+	rollback, err := createJSONArrayFile(filepath.Join(dir, "rollback.json"))
+	if err != nil {
+		return stats, err
+	}
+	defer rollback.close()
+
+	rows, err := db.Query(acceptedLinksQuery, minScore)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	var batch *jsonArrayFile
+	defer func() {
+		if batch != nil {
+			batch.close()
+		}
+	}()
+
+	for rows.Next() {
+		var olid, ocaid, reason string
+		var score float64
+		var current bool
+		if err := rows.Scan(&olid, &ocaid, &reason, &score, &current); err != nil {
+			return stats, err
+		}
+
+		if !current {
+			stats.stale++
+			continue
+		}
+
+		if batch == nil || batch.count == batchSize {
+			if batch != nil {
+				if err := batch.close(); err != nil {
+					return stats, err
+				}
+			}
+
+			stats.batches++
+			batch, err = createJSONArrayFile(filepath.Join(dir, fmt.Sprintf("edits-%05d.json", stats.batches)))
+			if err != nil {
+				return stats, err
+			}
+		}
+
+		key := "/books/" + olid
+		if err := batch.add(editPayload{
+			Key: key, Ocaid: ocaid, Comment: fmt.Sprintf("%s (%s, score %.2f)", comment, reason, score),
+		}); err != nil {
+			return stats, err
+		}
+
+		if err := rollback.add(editPayload{Key: key, Ocaid: "", Comment: "Revert: " + comment}); err != nil {
+			return stats, err
+		}
+		stats.edits++
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	if batch != nil {
+		if err := batch.close(); err != nil {
+			return stats, err
+		}
+	}
+	if err := rollback.close(); err != nil {
+		return stats, err
+	}
+
+	var accepted int
+	if err := db.QueryRow("SELECT count(*) FROM link_candidate WHERE score >= ?", minScore).Scan(&accepted); err != nil {
+		return stats, err
+	}
+	stats.ambiguous = accepted - stats.edits - stats.stale
+
+	return stats, nil
+}
+
+// jsonArrayFile streams values to a file as a JSON array.
+type jsonArrayFile struct {
+	f      *os.File
+	w      *bufio.Writer
+	count  int
+	closed bool
+}
+
+func createJSONArrayFile(path string) (*jsonArrayFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &jsonArrayFile{f: f, w: bufio.NewWriter(f)}
+	if _, err := a.w.WriteString("["); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *jsonArrayFile) add(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if a.count > 0 {
+		a.w.WriteString(",")
+	}
+	a.w.WriteString("\n  ")
+	if _, err := a.w.Write(data); err != nil {
+		return err
+	}
+
+	a.count++
+	return nil
+}
+
+// close ends the array and closes the file. It's safe to call more than once,
+// so it can be deferred as well.
+func (a *jsonArrayFile) close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	if _, err := a.w.WriteString("\n]\n"); err != nil {
+		a.f.Close()
+		return err
+	}
+	if err := a.w.Flush(); err != nil {
+		a.f.Close()
+		return err
+	}
+
+	return a.f.Close()
+}
+
+// runExport writes the edit payloads for the accepted link candidates in an
+// already reconciled DB to dir, and a summary to out.
+func runExport(dbName, dir string, batchSize int, minScore float64, comment string, out io.Writer) error {
+	db, err := getDB(dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats, err := exportEdits(db, dir, batchSize, minScore, comment)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "Wrote %d edits in %d batch files, and rollback.json, to %s. Skipped %d ambiguous links and %d linked since the reconcile.\n",
+		stats.edits, stats.batches, dir, stats.ambiguous, stats.stale)
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExportEdits(t *testing.T) {
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	loadTestRecords(t, db, newOLWriter,
+		&OpenLibraryEdition{olid: "OL001M"},
+		&OpenLibraryEdition{olid: "OL002M"},
+		&OpenLibraryEdition{olid: "OL003M"},
+		&OpenLibraryEdition{olid: "OL004M"},
+		// Given an ocaid by a load since the reconcile.
+		&OpenLibraryEdition{olid: "OL006M", ocaid: "otherscan"},
+		&OpenLibraryEdition{olid: "OL007M"},
+		&OpenLibraryEdition{olid: "OL008M"},
+		// Has IA008 as its ocaid.
+		&OpenLibraryEdition{olid: "OL009M", ocaid: "IA008"},
+	)
+	loadTestRecords(t, db, newIAWriter,
+		&IAItem{identifier: "IA001"},
+		&IAItem{identifier: "IA002"},
+		&IAItem{identifier: "IA003"},
+		&IAItem{identifier: "IA004"},
+		&IAItem{identifier: "IA044"},
+		&IAItem{identifier: "IA005"},
+		&IAItem{identifier: "IA006"},
+		// Linked to another edition since the reconcile.
+		&IAItem{identifier: "IA007", olEdition: "OL999M"},
+		&IAItem{identifier: "IA008"},
+	)
+
+	// OL004M has two accepted links, so neither is exported, and IA005 scores
+	// too low to be accepted. OL006M, OL007M and OL008M are stale.
+	if _, err := db.Exec(`INSERT INTO link_candidate (olid, ocaid, isbn_13, reason, score) VALUES
+	  ('OL001M', 'IA001', '9780141439518', 'unique_isbn', 0.95),
+	  ('OL002M', 'IA002', '9780135043943', 'unique_isbn', 0.9),
+	  ('OL003M', 'IA003', '', 'title_author_year', 1),
+	  ('OL004M', 'IA004', '9780000000002', 'unique_isbn', 0.9),
+	  ('OL004M', 'IA044', '9780000000002', 'unique_isbn', 0.9),
+	  ('OL001M', 'IA005', '9780141439518', 'unique_isbn', 0.5),
+	  ('OL006M', 'IA006', '', 'unique_lccn', 0.9),
+	  ('OL007M', 'IA007', '', 'unique_lccn', 0.9),
+	  ('OL008M', 'IA008', '', 'unique_lccn', 0.9)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// An earlier export with more batches, whose last shouldn't survive.
+	dir := t.TempDir()
+	for _, name := range []string{"edits-00007.json", "rollback.json", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("[]\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := exportEdits(db, dir, 2, 0.8, "Add ocaid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := (exportStats{edits: 3, batches: 2, ambiguous: 2, stale: 3}); stats != exp {
+		t.Fatalf("expected %+v, but got %+v", exp, stats)
+	}
+
+	files := map[string][]editPayload{
+		"edits-00001.json": {
+			{Key: "/books/OL001M", Ocaid: "IA001", Comment: "Add ocaid (unique_isbn, score 0.95)"},
+			{Key: "/books/OL002M", Ocaid: "IA002", Comment: "Add ocaid (unique_isbn, score 0.90)"},
+		},
+		"edits-00002.json": {
+			{Key: "/books/OL003M", Ocaid: "IA003", Comment: "Add ocaid (title_author_year, score 1.00)"},
+		},
+		"rollback.json": {
+			{Key: "/books/OL001M", Ocaid: "", Comment: "Revert: Add ocaid"},
+			{Key: "/books/OL002M", Ocaid: "", Comment: "Revert: Add ocaid"},
+			{Key: "/books/OL003M", Ocaid: "", Comment: "Revert: Add ocaid"},
+		},
+	}
+
+	for name, exp := range files {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []editPayload
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("%v: expected %+v, but got %+v", name, exp, got)
+		}
+	}
+
+	for _, name := range []string{"edits-00003.json", "edits-00007.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected no %v, but got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Fatalf("expected notes.txt to be kept, but got %v", err)
+	}
+
+	if _, err := exportEdits(db, dir, 0, 0.8, "Add ocaid"); !errors.Is(err, ErrorBadBatchSize) {
+		t.Fatalf("expected ErrorBadBatchSize, but got %v", err)
+	}
+
+	if _, err := exportEdits(db, dir, 2, 0, "Add ocaid"); !errors.Is(err, ErrorNoMinScore) {
+		t.Fatalf("expected ErrorNoMinScore, but got %v", err)
+	}
+}
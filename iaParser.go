@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/buger/jsonparser"
+)
+
+// IAItem holds the parts of an Internet Archive item's metadata needed to
+// link it with Open Library.
+type IAItem struct {
+	identifier  string
+	isbns       []string // Converted to ISBN 13.
+	olEdition   string
+	olWork      string
+	collections []string
+
+	// Used to score matches against OL editions.
+	title      string
+	date       string // Free text, e.g. 2006 or 2006-01-31.
+	publishers []string
+	imageCount int
+	languages  []string // Codes, e.g. eng, or names, e.g. English.
+	creators   []string // Authors, e.g. Brontë, Charlotte, 1816-1855.
+
+	// Matched against OL editions' lccn and oclc_numbers. Normalized when
+	// they're written.
+	lccns       []string
+	oclcNumbers []string
+}
+
+func NewIAItem(identifier string, isbns []string, olEdition, olWork string, collections []string) *IAItem {
+	return &IAItem{
+		identifier:  identifier,
+		isbns:       isbns,
+		olEdition:   olEdition,
+		olWork:      olWork,
+		collections: collections,
+	}
+}
+
+// iaPaths is a list of JSON paths parsed by buger/jasonparser.
+var iaPaths = [][]string{
+	{"identifier"},
+	{"isbn"},
+	{"openlibrary_edition"},
+	{"openlibrary_work"},
+	{"collection"},
+	{"title"},
+	{"date"},
+	{"publisher"},
+	{"imagecount"},
+	{"language"},
+	{"creator"},
+	{"lccn"},
+	{"oclc-id"},
+	{"external-identifier"},
+}
+
+// Unmarshal JSON data from the Internet Archive metadata dump into an *IAItem.
+func (i *IAItem) unmarshalJSON(jsonData []byte) error {
+	var innerErr error
+	jsonparser.EachKey(jsonData, func(idx int, v []byte, vt jsonparser.ValueType, err error) {
+		if err != nil {
+			innerErr = err
+			return
+		}
+
+		if vt == jsonparser.Null || vt == jsonparser.NotExist {
+			return
+		}
+
+		// The only number; it may also be a string.
+		if idx == 8 { // imagecount
+			imageCount, err := getIntFromValue(v, vt)
+			if err != nil {
+				innerErr = err
+				return
+			}
+			i.imageCount = imageCount
+			return
+		}
+
+		values, err := getStringsFromValue(v, vt)
+		if err != nil {
+			innerErr = err
+			return
+		}
+
+		if len(values) == 0 {
+			return
+		}
+
+		switch idx {
+		case 0: // identifier
+			i.identifier = values[0]
+
+		case 1: // isbn
+			for _, value := range values {
+				// Only keep values that can be compared against Open Library.
+				if isbn, _ := toComparableIsbn(value); isbn != "" {
+					i.isbns = append(i.isbns, isbn)
+				}
+			}
+
+		case 2: // openlibrary_edition
+			i.olEdition = getOlidFromKey(values[0])
+
+		case 3: // openlibrary_work
+			i.olWork = getOlidFromKey(values[0])
+
+		case 4: // collection
+			i.collections = values
+
+		case 5: // title
+			i.title = values[0]
+
+		case 6: // date
+			i.date = values[0]
+
+		case 7: // publisher
+			i.publishers = values
+
+		case 9: // language
+			i.languages = values
+
+		case 10: // creator
+			i.creators = values
+
+		case 11: // lccn
+			i.lccns = append(i.lccns, values...)
+
+		case 12: // oclc-id
+			i.oclcNumbers = append(i.oclcNumbers, values...)
+
+		case 13: // external-identifier, e.g. urn:oclc:record:1234567
+			for _, value := range values {
+				if oclc, ok := strings.CutPrefix(value, "urn:oclc:record:"); ok {
+					i.oclcNumbers = append(i.oclcNumbers, oclc)
+				} else if lccn, ok := strings.CutPrefix(value, "urn:lccn:"); ok {
+					i.lccns = append(i.lccns, lccn)
+				}
+			}
+		}
+	}, iaPaths...)
+
+	return innerErr
+}
+
+// getStringsFromValue reads an IA metadata value, which may be either a single
+// string or an array of strings, and returns the strings.
+func getStringsFromValue(v []byte, vt jsonparser.ValueType) ([]string, error) {
+	switch vt {
+	case jsonparser.String:
+		s, err := jsonparser.ParseString(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+
+	case jsonparser.Array:
+		var values []string
+		var innerErr error
+		jsonparser.ArrayEach(v, func(element []byte, evt jsonparser.ValueType, _ int, err error) {
+			if err != nil {
+				innerErr = err
+				return
+			}
+
+			if evt != jsonparser.String {
+				return
+			}
+
+			s, err := jsonparser.ParseString(element)
+			if err != nil {
+				innerErr = err
+				return
+			}
+			values = append(values, s)
+		})
+		return values, innerErr
+	}
+
+	return nil, nil
+}
+
+// parseIALine() reads a line from the Internet Archive metadata JSONL dump,
+// parses it, and returns an *IAItem.
+func parseIALine(line []byte) (*IAItem, error) {
+	i := IAItem{}
+	if err := i.unmarshalJSON(line); err != nil {
+		return nil, err
+	}
+
+	if i.identifier == "" {
+		return nil, ErrorNoIdentifier
+	}
+
+	return &i, nil
+}
+
+// IAParser is the Parser for the Internet Archive metadata JSONL dump.
+type IAParser struct{}
+
+// Parse parses a line with parseIALine, skipping blank lines.
+func (p *IAParser) Parse(line []byte) (Record, error) {
+	if len(line) == 0 {
+		return nil, ErrorSkipLine
+	}
+
+	return parseIALine(line)
+}
+
+// iaWriter is the recordWriter for the Internet Archive metadata dump. It
+// inserts items into the ia table, their ISBNs into the ia_isbn table, and
+// their normalized LCCNs and OCLC numbers into ia_identifier, in batches.
+type iaWriter struct {
+	items       *batchInserter
+	isbns       *batchInserter
+	identifiers *batchInserter
+}
+
+func newIAWriter(db sqlExecer, runID int64, batchSize int) (recordWriter, error) {
+	var err error
+	w := &iaWriter{}
+
+	w.items, err = newRunInserter(db, runID, "ia", []string{
+		"identifier", "ol_edition_id", "ol_work_id", "collection",
+		"title", "publish_date", "publishers", "image_count", "languages", "creators", "match_key",
+	}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.isbns, err = newRunInserter(db, runID, "ia_isbn", []string{"identifier", "isbn_13"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.identifiers, err = newRunInserter(db, runID, "ia_identifier", []string{"identifier", "name", "value"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *iaWriter) add(record Record) error {
+	item, ok := record.(*IAItem)
+	if !ok {
+		return fmt.Errorf("%T: %w", record, ErrorUnsupportedRecord)
+	}
+
+	// Store unknown image counts as NULL rather than 0.
+	var imageCount interface{}
+	if item.imageCount > 0 {
+		imageCount = item.imageCount
+	}
+
+	if err := w.items.add(
+		item.identifier, item.olEdition, item.olWork, strings.Join(item.collections, ";"),
+		item.title, item.date, strings.Join(item.publishers, ";"), imageCount, strings.Join(item.languages, ";"),
+		strings.Join(item.creators, ";"), getMatchKey(item.title, item.date),
+	); err != nil {
+		return err
+	}
+
+	for _, isbn := range item.isbns {
+		if err := w.isbns.add(item.identifier, isbn); err != nil {
+			return err
+		}
+	}
+
+	// Named as in edition_identifier, so the two can be joined.
+	for _, lccn := range item.lccns {
+		if lccn = normalizeLccn(lccn); lccn == "" {
+			continue
+		}
+		if err := w.identifiers.add(item.identifier, "lccn", lccn); err != nil {
+			return err
+		}
+	}
+
+	for _, oclc := range item.oclcNumbers {
+		if oclc = normalizeOclc(oclc); oclc == "" {
+			continue
+		}
+		if err := w.identifiers.add(item.identifier, "oclc", oclc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *iaWriter) close() error {
+	if err := w.items.close(); err != nil {
+		return err
+	}
+
+	if err := w.isbns.close(); err != nil {
+		return err
+	}
+
+	return w.identifiers.close()
+}
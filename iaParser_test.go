@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/buger/jsonparser"
+)
+
+func TestParseIALine(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		expItem *IAItem
+		expErr  error
+	}{
+		{
+			name:    "AllFields",
+			input:   `{"identifier": "seals0000bekk", "isbn": ["9781590368930", "1590368924"], "openlibrary_edition": "OL16775850M", "openlibrary_work": "OL1234W", "collection": ["inlibrary", "printdisabled"]}`,
+			expItem: &IAItem{identifier: "seals0000bekk", isbns: []string{"9781590368930", "9781590368923"}, olEdition: "OL16775850M", olWork: "OL1234W", collections: []string{"inlibrary", "printdisabled"}},
+		},
+		{
+			name:    "StringValues",
+			input:   `{"identifier": "IA001", "isbn": "0-13-504394-8", "collection": "inlibrary"}`,
+			expItem: &IAItem{identifier: "IA001", isbns: []string{"9780135043943"}, collections: []string{"inlibrary"}},
+		},
+		{
+			name:    "OLKeysBecomeOLIDs",
+			input:   `{"identifier": "IA002", "openlibrary_edition": "/books/OL002M", "openlibrary_work": "/works/OL002W"}`,
+			expItem: &IAItem{identifier: "IA002", olEdition: "OL002M", olWork: "OL002W"},
+		},
+		{
+			name:    "SkipUnusableISBNs",
+			input:   `{"identifier": "IA003", "isbn": ["123", "", "9788955565683"]}`,
+			expItem: &IAItem{identifier: "IA003", isbns: []string{"9788955565683"}},
+		},
+		{
+			name:    "NullValues",
+			input:   `{"identifier": "IA004", "isbn": null, "openlibrary_edition": null}`,
+			expItem: &IAItem{identifier: "IA004"},
+		},
+		{
+			name:  "ScoringFields",
+			input: `{"identifier": "IA005", "title": "Jane Eyre", "date": "2006", "publisher": "Penguin", "imagecount": "560", "language": ["eng", "English"], "creator": "Brontë, Charlotte, 1816-1855"}`,
+			expItem: &IAItem{
+				identifier: "IA005", title: "Jane Eyre", date: "2006", publishers: []string{"Penguin"}, imageCount: 560,
+				languages: []string{"eng", "English"}, creators: []string{"Brontë, Charlotte, 1816-1855"},
+			},
+		},
+		{
+			name:    "NumericImageCount",
+			input:   `{"identifier": "IA006", "imagecount": 212}`,
+			expItem: &IAItem{identifier: "IA006", imageCount: 212},
+		},
+		{
+			name:    "Identifiers",
+			input:   `{"identifier": "IA007", "lccn": "2001012345", "oclc-id": ["ocm00012345"], "external-identifier": ["urn:oclc:record:67890", "urn:lccn:85000001", "urn:isbn:9788955565683"]}`,
+			expItem: &IAItem{identifier: "IA007", lccns: []string{"2001012345", "85000001"}, oclcNumbers: []string{"ocm00012345", "67890"}},
+		},
+		{
+			// A bad field before imagecount isn't lost when imagecount parses.
+			name:   "BadFieldBeforeImageCount",
+			input:  `{"identifier": "IA008", "title": "Jane \uZZZZ Eyre", "imagecount": 212}`,
+			expErr: jsonparser.MalformedValueError,
+		},
+		{
+			name:   "NoIdentifier",
+			input:  `{"isbn": ["9788955565683"]}`,
+			expErr: ErrorNoIdentifier,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			item, err := parseIALine([]byte(tc.input))
+			if tc.expErr != nil {
+				if !errors.Is(err, tc.expErr) {
+					t.Fatalf("expected %q, but got %q instead", tc.expErr, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(tc.expItem, item) {
+				t.Fatalf("expected: %v, but got %v", tc.expItem, item)
+			}
+		})
+	}
+}
+
+func TestGetIAItems(t *testing.T) {
+	var resItems []*IAItem
+	recordsCh := make(chan Record)
+	doneCh := make(chan struct{})
+	errCh := make(chan error)
+	inFile := filepath.Join(t.TempDir(), "ia.jsonl")
+	data := `{"identifier": "IA001", "isbn": ["9788955565683"], "openlibrary_edition": "OL001M"}
+{"identifier": "IA002", "isbn": ["0135043948"]}
+{"identifier": "IA003"}
+`
+	if err := os.WriteFile(inFile, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	expItems := []*IAItem{
+		{identifier: "IA001", isbns: []string{"9788955565683"}, olEdition: "OL001M"},
+		{identifier: "IA002", isbns: []string{"9780135043943"}},
+		{identifier: "IA003"},
+	}
+
+	go func() {
+		for record := range recordsCh {
+			resItems = append(resItems, record.(*IAItem))
+		}
+		defer close(doneCh)
+	}()
+
+	// A small chunk size so the file is split into several chunks.
+	if err := getRecords(context.Background(), inFile, &IAParser{}, io.Discard, recordsCh, doneCh, errCh, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(resItems, func(i, j int) bool {
+		return resItems[i].identifier < resItems[j].identifier
+	})
+
+	if !reflect.DeepEqual(expItems, resItems) {
+		t.Fatalf("expected %v, but got %v", expItems, resItems)
+	}
+}
+
+func TestAddIAItemToDBBatch(t *testing.T) {
+	itemsCh := make(chan Record)
+	items := []*IAItem{
+		NewIAItem("IA001", []string{"9788955565683", "9780135043943"}, "OL001M", "OL001W", []string{"inlibrary", "printdisabled"}),
+		NewIAItem("IA002", nil, "", "", nil),
+		NewIAItem("IA003", []string{"9781590368930"}, "", "", []string{"inlibrary"}),
+	}
+
+	go func() {
+		defer close(itemsCh)
+		for _, item := range items {
+			itemsCh <- item
+		}
+	}()
+
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch size of 2 ensures "underflow" batches are handled.
+	run, finish := testRun(t, db, "ia")
+	writer, err := newIAWriter(db, run.id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = addRecordsToDBBatch(context.Background(), itemsCh, writer); err != nil {
+		t.Fatal(err)
+	}
+	finish()
+
+	rows, err := db.Query("SELECT identifier, ol_edition_id, ol_work_id, collection FROM ia ORDER BY identifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var resItems [][]string
+	for rows.Next() {
+		var identifier, olEdition, olWork, collection string
+		if err := rows.Scan(&identifier, &olEdition, &olWork, &collection); err != nil {
+			t.Fatal(err)
+		}
+		resItems = append(resItems, []string{identifier, olEdition, olWork, collection})
+	}
+
+	expItems := [][]string{
+		{"IA001", "OL001M", "OL001W", "inlibrary;printdisabled"},
+		{"IA002", "", "", ""},
+		{"IA003", "", "", "inlibrary"},
+	}
+	if !reflect.DeepEqual(expItems, resItems) {
+		t.Fatalf("expected %v, but got %v", expItems, resItems)
+	}
+
+	var isbnCount int
+	if err := db.QueryRow("SELECT COUNT(*) FROM ia_isbn").Scan(&isbnCount); err != nil {
+		t.Fatal(err)
+	}
+
+	if isbnCount != 3 {
+		t.Fatalf("expected 3 rows in ia_isbn, but got %d", isbnCount)
+	}
+}
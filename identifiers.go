@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// oclcPrefixes are the prefixes OCLC numbers carry in MARC 035 fields, e.g.
+// "(OCoLC)ocm12345678". Longer prefixes come first.
+var oclcPrefixes = []string{"ocm", "ocn", "on"}
+
+// normalizeLccn normalizes an LCCN as described at
+// https://www.loc.gov/marc/lccn-namespace.html so that the same number
+// compares equal across sources: blanks and anything after a slash are
+// removed, and a hyphenated serial number is zero padded to six digits.
+// "  85-2 " becomes "85000002".
+func normalizeLccn(lccn string) string {
+	lccn = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, lccn)
+
+	if i := strings.Index(lccn, "/"); i >= 0 {
+		lccn = lccn[:i]
+	}
+
+	if i := strings.Index(lccn, "-"); i >= 0 {
+		serial := lccn[i+1:]
+		if len(serial) < 6 {
+			serial = strings.Repeat("0", 6-len(serial)) + serial
+		}
+		lccn = lccn[:i] + serial
+	}
+
+	return strings.ToLower(lccn)
+}
+
+// normalizeOclc strips the "(OCoLC)" label, the ocm/ocn/on prefixes and
+// leading zeros from an OCLC number. Anything that isn't a number once those
+// are removed returns "".
+func normalizeOclc(oclc string) string {
+	oclc = strings.TrimSpace(oclc)
+	oclc = strings.TrimPrefix(oclc, "(OCoLC)")
+	for _, prefix := range oclcPrefixes {
+		if strings.HasPrefix(oclc, prefix) {
+			oclc = oclc[len(prefix):]
+			break
+		}
+	}
+
+	oclc = strings.TrimLeft(strings.TrimSpace(oclc), "0")
+	if oclc == "" {
+		return ""
+	}
+
+	for _, c := range oclc {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+
+	return oclc
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestNormalizeLccn(t *testing.T) {
+	tests := []struct {
+		lccn string
+		exp  string
+	}{
+		{lccn: "2001012345", exp: "2001012345"},
+		{lccn: "   85000002 ", exp: "85000002"},
+		{lccn: "85-2", exp: "85000002"},
+		{lccn: "n78-890351", exp: "n78890351"},
+		{lccn: "sn 78000123 ", exp: "sn78000123"},
+		{lccn: "79139101 /AC/r932", exp: "79139101"},
+		{lccn: "", exp: ""},
+	}
+
+	for _, tc := range tests {
+		if res := normalizeLccn(tc.lccn); res != tc.exp {
+			t.Fatalf("%q: expected %s, but got %s", tc.lccn, tc.exp, res)
+		}
+	}
+}
+
+func TestNormalizeOclc(t *testing.T) {
+	tests := []struct {
+		oclc string
+		exp  string
+	}{
+		{oclc: "71126926", exp: "71126926"},
+		{oclc: "(OCoLC)71126926", exp: "71126926"},
+		{oclc: "(OCoLC)ocm00012345", exp: "12345"},
+		{oclc: "(OCoLC)ocn123456789", exp: "123456789"},
+		{oclc: "(OCoLC)on1234567890", exp: "1234567890"},
+		{oclc: "(OCoLC)abc", exp: ""},
+		{oclc: "000", exp: ""},
+	}
+
+	for _, tc := range tests {
+		if res := normalizeOclc(tc.oclc); res != tc.exp {
+			t.Fatalf("%q: expected %s, but got %s", tc.oclc, tc.exp, res)
+		}
+	}
+}
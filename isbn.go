@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const PREFIX string = "978"
+
+// IsbnStatus classifies an ISBN as found in a dump.
+type IsbnStatus int
+
+const (
+	IsbnNone        IsbnStatus = iota // No ISBN at all.
+	IsbnValid                         // Correct length and checksum.
+	IsbnBadChecksum                   // Correct length, but the check digit doesn't match.
+	IsbnWrongLength                   // Only digits, but neither 10 nor 13 of them.
+	IsbnGarbage                       // Characters that don't belong in an ISBN.
+)
+
+var isbnStatusNames = [...]string{
+	IsbnNone:        "",
+	IsbnValid:       "valid",
+	IsbnBadChecksum: "bad_checksum",
+	IsbnWrongLength: "wrong_length",
+	IsbnGarbage:     "garbage",
+}
+
+func (s IsbnStatus) String() string {
+	if int(s) < len(isbnStatusNames) {
+		return isbnStatusNames[s]
+	}
+	return fmt.Sprintf("IsbnStatus(%d)", int(s))
+}
+
+// usable reports whether an ISBN with this status is still worth comparing
+// against other sources. Bad checksums are kept because the same typo is often
+// copied from one catalog record to another.
+func (s IsbnStatus) usable() bool {
+	return s == IsbnValid || s == IsbnBadChecksum
+}
+
+// isbnReplacer strips the punctuation commonly found within ISBNs.
+var isbnReplacer = strings.NewReplacer("-", "", " ", "", ".", "", " ", "")
+
+// isbnPrefixes are labels that sometimes precede the ISBN itself. Longer
+// labels come first so that "ISBN-13" isn't read as "ISBN" followed by "-13".
+var isbnPrefixes = []string{"ISBN-13", "ISBN-10", "ISBN13", "ISBN10", "ISBN"}
+
+// normalizeIsbn strips labels such as "ISBN:", qualifiers such as "(pbk.)",
+// hyphens and spaces from isbn, and upper cases a trailing x.
+// "ISBN: 0-14-143951-3 (pbk.)" becomes "0141439513".
+func normalizeIsbn(isbn string) string {
+	if i := strings.IndexAny(isbn, "(;"); i >= 0 {
+		isbn = isbn[:i]
+	}
+
+	isbn = strings.ToUpper(strings.TrimSpace(isbn))
+	for _, prefix := range isbnPrefixes {
+		if strings.HasPrefix(isbn, prefix) {
+			isbn = strings.TrimLeft(isbn[len(prefix):], ": ")
+			break
+		}
+	}
+
+	return isbnReplacer.Replace(isbn)
+}
+
+// classifyIsbn returns the IsbnStatus of an already normalized ISBN.
+func classifyIsbn(isbn string) IsbnStatus {
+	if isbn == "" {
+		return IsbnNone
+	}
+
+	for i, c := range isbn {
+		// X is only a valid check digit for an ISBN 10.
+		if c == 'X' && i == 9 && len(isbn) == 10 {
+			continue
+		}
+		if c < '0' || c > '9' {
+			return IsbnGarbage
+		}
+	}
+
+	var checkDigit string
+	var err error
+	switch len(isbn) {
+	case 10:
+		checkDigit, err = getIsbn10CheckDigit(isbn)
+	case 13:
+		checkDigit, err = getIsbn13CheckDigit(isbn)
+	default:
+		return IsbnWrongLength
+	}
+
+	if err != nil {
+		return IsbnGarbage
+	}
+
+	if checkDigit != isbn[len(isbn)-1:] {
+		return IsbnBadChecksum
+	}
+
+	return IsbnValid
+}
+
+// getIsbn10CheckDigit calculates the check digit for an ISBN 10 based on the
+// first nine digits of the number. Works with a full ISBN 10 or just the first
+// 9 digits.
+func getIsbn10CheckDigit(isbn string) (string, error) {
+	if len(isbn) < 9 {
+		return "", fmt.Errorf("%v: %w", isbn, ErrorWrongIsbnLength)
+	}
+
+	var sum int
+	for i, c := range isbn[:9] {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("%v: %w", isbn, ErrorIsbnNotNumeric)
+		}
+		sum += (10 - i) * int(c-'0')
+	}
+
+	checkDigit := (11 - sum%11) % 11
+	if checkDigit == 10 {
+		return "X", nil
+	}
+	return fmt.Sprint(checkDigit), nil
+}
+
+// getIsbn13CheckDigit calculates the check digit for an ISBN 13 based on the
+// first twelve digits of the number. Works with a full ISBN 13 or just the
+// first 12 digits.
+// NOTE: This does *NOT* verify that the ISBN 10, and therefore ISBN 13, is valid,
+// so it can produce invalid ISBN 13s based on invalid ISBN 10s.
+func getIsbn13CheckDigit(isbn string) (string, error) {
+	if len(isbn) < 12 {
+		return "", fmt.Errorf("%v: %w", isbn, ErrorWrongIsbnLength)
+	}
+
+	// Formula adapted from xlcnd/isbnlib
+	// https://github.com/xlcnd/isbnlib/blob/f4e7339ced8d42939318ce3adc7823a45fcd1c5b/isbnlib/_core.py#L77
+	var sum int
+	for i, c := range isbn[:12] {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("%v: %w", isbn, ErrorIsbnNotNumeric)
+		}
+		sum += (i%2*2 + 1) * int(c-'0')
+	}
+
+	return fmt.Sprint((10 - sum%10) % 10), nil
+}
+
+// isbn10To13 converts an ISBN 10 to an ISBN 13 by prefixing it with 978 and
+// recalculating the check digit.
+func isbn10To13(isbn10 string) (string, error) {
+	if len(isbn10) != 10 {
+		return "", fmt.Errorf("%v: %w", isbn10, ErrorWrongIsbnLength)
+	}
+
+	firstTwelve := PREFIX + isbn10[:9]
+	checkDigit, err := getIsbn13CheckDigit(firstTwelve)
+	if err != nil {
+		return "", err
+	}
+
+	return firstTwelve + checkDigit, nil
+}
+
+// isbn13To10 converts an ISBN 13 to an ISBN 10. Only ISBN 13s with the 978
+// prefix have an ISBN 10 equivalent.
+func isbn13To10(isbn13 string) (string, error) {
+	if len(isbn13) != 13 {
+		return "", fmt.Errorf("%v: %w", isbn13, ErrorWrongIsbnLength)
+	}
+
+	if !strings.HasPrefix(isbn13, PREFIX) {
+		return "", fmt.Errorf("%v: %w", isbn13, ErrorNoIsbn10)
+	}
+
+	firstNine := isbn13[3:12]
+	checkDigit, err := getIsbn10CheckDigit(firstNine)
+	if err != nil {
+		return "", err
+	}
+
+	return firstNine + checkDigit, nil
+}
+
+// toComparableIsbn normalizes and classifies a raw ISBN from a dump, and
+// returns it as an ISBN 13 if it's usable for matching. The status is
+// returned either way so callers can record why an ISBN was dropped.
+func toComparableIsbn(raw string) (string, IsbnStatus) {
+	isbn := normalizeIsbn(raw)
+	status := classifyIsbn(isbn)
+	if !status.usable() {
+		return "", status
+	}
+
+	if len(isbn) == 10 {
+		isbn13, err := isbn10To13(isbn)
+		if err != nil {
+			return "", IsbnGarbage
+		}
+		return isbn13, status
+	}
+
+	return isbn, status
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestCheckDigit13 verifies a few check digits for ISBN 13.
+func TestCheckDigit13(t *testing.T) {
+	tests := []struct {
+		isbn string
+		exp  string
+	}{
+		{isbn: "978819010750", exp: "1"},
+		{isbn: "9781590368923", exp: "3"},
+		{isbn: "9781590368930", exp: "0"},
+	}
+
+	for _, tc := range tests {
+		res, err := getIsbn13CheckDigit(tc.isbn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res != tc.exp {
+			t.Fatalf("Expected %s, but got %s", tc.exp, res)
+		}
+	}
+
+	if _, err := getIsbn13CheckDigit("97814/4395X12"); !errors.Is(err, ErrorIsbnNotNumeric) {
+		t.Fatalf("Expected %q, but got %q", ErrorIsbnNotNumeric, err)
+	}
+}
+
+// TestCheckDigit10 verifies a few check digits for ISBN 10, including X.
+func TestCheckDigit10(t *testing.T) {
+	tests := []struct {
+		isbn string
+		exp  string
+	}{
+		{isbn: "014143951", exp: "3"},
+		{isbn: "819010750", exp: "X"},
+		{isbn: "0135043948", exp: "8"},
+	}
+
+	for _, tc := range tests {
+		res, err := getIsbn10CheckDigit(tc.isbn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res != tc.exp {
+			t.Fatalf("Expected %s, but got %s", tc.exp, res)
+		}
+	}
+}
+
+func TestNormalizeIsbn(t *testing.T) {
+	tests := []struct {
+		isbn string
+		exp  string
+	}{
+		{isbn: "0141439513", exp: "0141439513"},
+		{isbn: "0-14-143951-3", exp: "0141439513"},
+		{isbn: " 0 14 143951 3 ", exp: "0141439513"},
+		{isbn: "ISBN: 0-14-143951-3", exp: "0141439513"},
+		{isbn: "isbn 819010750x", exp: "819010750X"},
+		{isbn: "ISBN-13: 978-0-14-143951-8", exp: "9780141439518"},
+		{isbn: "0141439513 (pbk.)", exp: "0141439513"},
+		{isbn: "0141439513; alk. paper", exp: "0141439513"},
+	}
+
+	for _, tc := range tests {
+		if res := normalizeIsbn(tc.isbn); res != tc.exp {
+			t.Fatalf("%q: expected %s, but got %s", tc.isbn, tc.exp, res)
+		}
+	}
+}
+
+func TestClassifyIsbn(t *testing.T) {
+	tests := []struct {
+		isbn string
+		exp  IsbnStatus
+	}{
+		{isbn: "", exp: IsbnNone},
+		{isbn: "0141439513", exp: IsbnValid},
+		{isbn: "819010750X", exp: IsbnValid},
+		{isbn: "9780141439518", exp: IsbnValid},
+		{isbn: "0141439514", exp: IsbnBadChecksum},
+		{isbn: "222222222X", exp: IsbnBadChecksum},
+		{isbn: "1234567890123", exp: IsbnBadChecksum},
+		{isbn: "123", exp: IsbnWrongLength},
+		{isbn: "01414395130", exp: IsbnWrongLength},
+		{isbn: "X141439513", exp: IsbnGarbage},
+		{isbn: "978014143951X", exp: IsbnGarbage},
+		{isbn: "97814/4395X", exp: IsbnGarbage},
+	}
+
+	for _, tc := range tests {
+		if res := classifyIsbn(tc.isbn); res != tc.exp {
+			t.Fatalf("%q: expected %v, but got %v", tc.isbn, tc.exp, res)
+		}
+	}
+}
+
+func TestIsbn10To13(t *testing.T) {
+	res, err := isbn10To13("0141439513")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "9780141439518"; res != exp {
+		t.Fatalf("Expected %s, but got %s", exp, res)
+	}
+
+	if _, err := isbn10To13("123"); !errors.Is(err, ErrorWrongIsbnLength) {
+		t.Fatalf("Expected %q, but got %q", ErrorWrongIsbnLength, err)
+	}
+}
+
+func TestIsbn13To10(t *testing.T) {
+	tests := []struct {
+		isbn   string
+		exp    string
+		expErr error
+	}{
+		{isbn: "9780141439518", exp: "0141439513"},
+		{isbn: "9788190107501", exp: "819010750X"},
+		{isbn: "9791234567896", expErr: ErrorNoIsbn10},
+		{isbn: "0141439513", expErr: ErrorWrongIsbnLength},
+	}
+
+	for _, tc := range tests {
+		res, err := isbn13To10(tc.isbn)
+		if !errors.Is(err, tc.expErr) {
+			t.Fatalf("%s: expected %v, but got %v", tc.isbn, tc.expErr, err)
+		}
+
+		if res != tc.exp {
+			t.Fatalf("%s: expected %s, but got %s", tc.isbn, tc.exp, res)
+		}
+	}
+}
+
+func TestToComparableIsbn(t *testing.T) {
+	tests := []struct {
+		raw       string
+		exp       string
+		expStatus IsbnStatus
+	}{
+		{raw: "0-14-143951-3", exp: "9780141439518", expStatus: IsbnValid},
+		{raw: "9780141439518", exp: "9780141439518", expStatus: IsbnValid},
+		{raw: "222222222X", exp: "9782222222224", expStatus: IsbnBadChecksum},
+		{raw: "123", exp: "", expStatus: IsbnWrongLength},
+		{raw: "n/a", exp: "", expStatus: IsbnGarbage},
+	}
+
+	for _, tc := range tests {
+		res, status := toComparableIsbn(tc.raw)
+		if res != tc.exp || status != tc.expStatus {
+			t.Fatalf("%q: expected %s (%v), but got %s (%v)", tc.raw, tc.exp, tc.expStatus, res, status)
+		}
+	}
+}
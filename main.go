@@ -1,117 +1,412 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
-	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
+// modifiedAfter is set from -modified-after. runSeek skips editions last
+// modified at or before it, and reconcile, conflicts and dangling leave them
+// out, unless it's zero.
+var modifiedAfter time.Time
+
+// bulkLoad is set from -bulk. Loads drop the indexes first when it's true, and
+// always build them at the end.
+var bulkLoad = true
+
 func main() {
 	// Flags
-	runType := flag.String("type", "", "Which iteration of run() to use")
-	inFileOL := flag.String("oldump", "", "Open Library ALL dump file")
+	runType := flag.String("type", "", "Which iteration of run() to use, reconcile, conflicts, dangling or duplicates to report on an earlier load, export to write edits for it, runs to list the loads, diff, or index to build the indexes for a DB loaded without them")
+	inFileOL := flag.String("oldump", "", "Open Library ALL dump file (may be .gz, .bz2 or .zst), or - for stdin")
+	inFileIA := flag.String("iadump", "", "Internet Archive metadata JSONL file (may be .gz, .bz2 or .zst), or - for stdin")
+	inFileMARC := flag.String("marc", "", "MARC21 binary or MARCXML file (may be .gz, .bz2 or .zst), or - for stdin")
+	fields := flag.String("fields", "all", "Comma separated optional edition fields to parse (e.g. title,publishers), all or none")
+	after := flag.String("modified-after", "", "Only load, or reconcile or report conflicts and dangling links for, editions last modified after this date (e.g. 2023-01-31) or timestamp")
+	format := flag.String("format", "tsv", "Report format: tsv, csv, jsonl or html")
+	minScore := flag.Float64("min-score", 0, "Only report or export link candidates with at least this confidence score, from 0 to 1 (required for export)")
+	outDir := flag.String("out-dir", "edits", "Directory export writes the edit batch files and rollback.json to")
+	batchSize := flag.Int("batch-size", 1000, "Number of edits in each export batch file")
+	comment := flag.String("comment", "Add ocaid from reconcile-go", "Change comment for exported edits")
+	oldLoad := flag.String("old", "", "For diff, the earlier load's DB, or an OL dump")
+	newLoad := flag.String("new", DBFILE, "For diff, the later load's DB, or an OL dump")
+	oldRun := flag.Int64("old-run", 0, "For diff, the ID of an OL run, from -type runs, to compare in the -old DB, or the -new DB if there's no -old")
+	newRun := flag.Int64("new-run", 0, "For diff, the ID of an OL run to compare in the -new DB, rather than its latest")
+	flag.StringVar(&storeBackend, "store", "sqlite", "Where loads go and reconcile reads from: sqlite, or bolt, which supports ISBN reconciliation only and doesn't need cgo")
+	flag.IntVar(&keepRuns, "keep-runs", 0, "After a load, keep the rows of only this many of the latest complete runs of its kind; 0 keeps every run. Not supported by -store bolt")
+	flag.BoolVar(&bulkLoad, "bulk", true, "Drop the loaded tables' indexes while loading and rebuild them after; use -bulk=false for small loads into a large DB")
 	flag.Parse()
 
-	// Determine which infile to use for run().
-	var inFile *string
-	if *inFileOL != "" {
-		inFile = inFileOL
+	var err error
+	if editionFields, err = parseEditionFields(*fields); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if modifiedAfter, err = parseModifiedAfter(*after); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
+	// Interrupting a load cancels it, which rolls it back.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	switch *runType {
 	case "runSeek":
-		if err := runSeek(*inFile, os.Stdout); err != nil {
+		// Load whichever dumps were given.
+		if *inFileOL != "" {
+			if err := runSeek(ctx, *inFileOL, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		if *inFileIA != "" {
+			if err := runSeekIA(ctx, *inFileIA, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		if *inFileMARC != "" {
+			if err := runMARC(ctx, *inFileMARC, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+	case "reconcile", "conflicts", "dangling", "duplicates", "runs":
+		// Reports on an earlier load.
+		report := func() error { return runReport(*runType, DBNAME, *format, *minScore, modifiedAfter, os.Stdout) }
+		if storeBackend != "sqlite" {
+			report = func() error {
+				return runStoreReport(*runType, storeBackend, *format, *minScore, modifiedAfter, os.Stdout)
+			}
+		}
+		if err := report(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "diff":
+		// Editions added, removed or changed between two loads.
+		if err := runDiff(ctx, *oldLoad, *newLoad, *oldRun, *newRun, *format, os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "index":
+		if err := runIndex(DBNAME); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "export":
+		// Edits for the links accepted by an earlier reconcile.
+		if err := runExport(DBNAME, *outDir, *batchSize, *minScore, *comment, os.Stdout); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 	}
 }
 
-func runSeek(inFile string, out io.Writer) error {
+// runSeek loads the Open Library dump into the store.
+func runSeek(ctx context.Context, inFile string, out io.Writer) error {
+	store, err := openStore(storeBackend)
+	if err != nil {
+		return err
+	}
+	defer store.close()
+
+	return loadDump(ctx, store, "ol", inFile, NewOpenLibraryParser(editionFields, modifiedAfter), out)
+}
+
+// runSeekIA loads the Internet Archive metadata dump into the store.
+func runSeekIA(ctx context.Context, inFile string, out io.Writer) error {
+	store, err := openStore(storeBackend)
+	if err != nil {
+		return err
+	}
+	defer store.close()
+
+	return loadDump(ctx, store, "ia", inFile, &IAParser{}, out)
+}
+
+// runMARC loads a MARC21 binary or MARCXML file into the marc table. The
+// format is detected from the content rather than the file name.
+func runMARC(ctx context.Context, inFile string, out io.Writer) error {
+	store, err := openStore(storeBackend)
+	if err != nil {
+		return err
+	}
+	defer store.close()
+
+	// MARC files are always read as a stream, so they're hashed as they're
+	// read.
+	run := newLoadRun("marc", inFile)
+
+	var rc io.ReadCloser
+	source := filepath.Base(inFile)
+	if inFile == "-" {
+		source = "stdin"
+		rc, err = decompressStream(io.TeeReader(os.Stdin, run.hashStream()))
+	} else {
+		rc, err = openDumpTee(inFile, run.hashStream())
+	}
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(rc)
+	r := &dumpReader{Reader: br, closers: []io.Closer{rc}}
+
+	return loadRecords(ctx, store, run, out, func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error {
+		if !isMARCXML(br) {
+			streamDump(ctx, g, r, NewMARCParser(source), recordsCh, errCh)
+			return nil
+		}
+
+		g.Go(func() error {
+			defer r.Close()
+			if err := readMARCXML(ctx, r, source, recordsCh); err != nil {
+				return err
+			}
+
+			// Anything after the collection is read too, so all of the
+			// file is hashed.
+			_, err := io.Copy(io.Discard, r)
+			return err
+		})
+		return nil
+	})
+}
+
+// parseFunc starts the GoRoutines, in g, that parse a dump and send its
+// records to recordsCh and its parse errors to errCh. They must stop once ctx
+// is cancelled, which happens when any of them fails.
+type parseFunc func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error
+
+// loadDump parses inFile with parser and adds the records to store, in a
+// run of kind. An OpenLibraryParser's modifiedAfter makes it an incremental
+// run, which is laid over the previous snapshot.
+func loadDump(ctx context.Context, store Store, kind, inFile string, parser Parser, out io.Writer) error {
 	chunkSize := int64(1000 * 1000 * 1000)
-	doneCh := make(chan struct{})
-	editionsCh := make(chan *OpenLibraryEdition, 256)
+
+	run := newLoadRun(kind, inFile)
+	if p, ok := parser.(*OpenLibraryParser); ok {
+		run.modifiedAfter = p.modifiedAfter
+	}
+
+	return loadRecords(ctx, store, run, out, func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error {
+		return parseDump(ctx, g, run, parser, chunkSize, recordsCh, errCh)
+	})
+}
+
+// loadRecords adds the records sent by parse to store with its writer, as
+// run. The first error from the parsers or the writer cancels the rest, aborts
+// the writer and is returned. Either way, the end of the run is recorded.
+func loadRecords(ctx context.Context, store Store, run *loadRun, out io.Writer, parse parseFunc) error {
+	recordsCh := make(chan Record, 256)
 	errCh := make(chan error, 5)
-	dbName := DBNAME
 
-	// Get a DB
-	db, err := getDB(dbName)
+	writer, err := store.writer(run, 250)
 	if err != nil {
 		return err
 	}
 
-	// Add editions from editionsCh
-	go func() {
-		addEditionToDBBatch(editionsCh, doneCh, db, 250)
-	}()
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return runParsers(ctx, parse, recordsCh, errCh)
+	})
+	g.Go(func() error {
+		return addRecordsToDBBatch(ctx, recordsCh, writer)
+	})
 
-	if err := getEditions(inFile, out, editionsCh, doneCh, errCh, chunkSize); err != nil {
-		return err
+	// Blocks until done
+	run.errors, err = waitForRecords(g, out, errCh)
+	if runErr := store.endRun(run, err); runErr != nil {
+		if err == nil {
+			return runErr
+		}
+		return fmt.Errorf("%w (recording the run: %v)", err, runErr)
 	}
 
-	// Block until done
-	<-doneCh
+	return err
+}
+
+// runParsers runs parse and waits for the GoRoutines it starts, then closes
+// recordsCh, which tells addRecordsToDBBatch there are no more records. If
+// they fail, recordsCh is left open, so the writer is aborted by the
+// cancellation rather than closed.
+func runParsers(ctx context.Context, parse parseFunc, recordsCh chan<- Record, errCh chan<- error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return parse(ctx, g, recordsCh, errCh)
+	})
 
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	close(recordsCh)
 	return nil
 }
 
-func getEditions(inFile string, out io.Writer, editionsCh chan<- *OpenLibraryEdition, doneCh <-chan struct{}, errCh chan error, chunkSize int64) error {
-	chunksCh := make(chan *Chunk, 20)
-	// doneCh := make(chan struct{})
-	wg := sync.WaitGroup{}
+// runIndex builds any missing indexes in an already loaded DB.
+func runIndex(dbName string) error {
+	db, err := getDB(dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return createIndexes(db, "")
+}
+
+// waitForRecords prints the parse errors from errCh to out until the
+// GoRoutines in g are done, then returns how many there were and the first
+// error from g.
+func waitForRecords(g *errgroup.Group, out io.Writer, errCh <-chan error) (int, error) {
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- g.Wait()
+	}()
+
+	var parseErrors int
+	for {
+		select {
+		case err := <-errCh:
+			parseErrors++
+			fmt.Fprintln(out, err)
+		case err := <-doneCh:
+			// Errors sent just before the GoRoutines finished may still be
+			// buffered.
+			for {
+				select {
+				case parseErr := <-errCh:
+					parseErrors++
+					fmt.Fprintln(out, parseErr)
+				default:
+					return parseErrors, err
+				}
+			}
+		}
+	}
+}
+
+// parseDump parses every line of run's dump file with parser, using one
+// GoRoutine per processor, started in g, and hashes it for run. A dump file of
+// "-" reads from stdin.
+func parseDump(ctx context.Context, g *errgroup.Group, run *loadRun, parser Parser, chunkSize int64, recordsCh chan<- Record, errCh chan<- error) error {
+	inFile := run.dumpFile
+	if inFile == "-" {
+		r, err := decompressStream(io.TeeReader(os.Stdin, run.hashStream()))
+		if err != nil {
+			return err
+		}
+
+		streamDump(ctx, g, r, parser, recordsCh, errCh)
+		return nil
+	}
 
-	f, err := os.Open(inFile)
+	seekable, err := isSeekable(inFile)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	chunks, err := getChunks(chunkSize, inFile)
+	// Compressed dumps and pipes can't be chunked with Seek, and chunks always
+	// end on a newline, so stream those and dumps with other record separators.
+	_, splits := parser.(recordSplitter)
+	if isCompressed(inFile) || !seekable || splits {
+		r, err := openDumpTee(inFile, run.hashStream())
+		if err != nil {
+			return err
+		}
+
+		streamDump(ctx, g, r, parser, recordsCh, errCh)
+		return nil
+	}
+
+	hashDump(ctx, g, run)
+	return chunkDump(ctx, g, inFile, parser, chunkSize, recordsCh, errCh)
+}
+
+// chunkDump splits inFile into chunks and spins up one GoRoutine per
+// processor, in g, to parse them.
+func chunkDump(ctx context.Context, g *errgroup.Group, inFile string, parser Parser, chunkSize int64, recordsCh chan<- Record, errCh chan<- error) error {
+	chunksCh := make(chan *Chunk, 20)
+
+	chunks, err := getChunks(chunkSize, inFile, parser)
 	if err != nil {
 		return err
 	}
 
-	go func() {
+	g.Go(func() error {
+		defer close(chunksCh)
 		for _, chunk := range chunks {
-			chunksCh <- chunk
+			select {
+			case chunksCh <- chunk:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
-		defer close(chunksCh)
-	}()
+		return nil
+	})
 
 	// Spin up one GoRoutine per processor and grab chunks until they're gone.
 	for i := 0; i < runtime.NumCPU(); i++ {
-		// for i := 0; i < 1; i++ {
-		wg.Add(1)
-
-		go func() {
-			defer wg.Done()
-
+		g.Go(func() error {
 			// Each GoRoutine grabs chunks until there are no more.
 			for chunk := range chunksCh {
-				chunk.Process(editionsCh, errCh)
+				if err := chunk.Process(ctx, recordsCh, errCh); err != nil {
+					return err
+				}
 			}
-		}()
+			return nil
+		})
 	}
 
-	// Once all the chunk.Process GoRoutines finish, no more editions
-	// will be sent to editionsCh. Closing the channel tells addEditionToDBBatch
-	// that there are no more editions to add to the DB.
-	go func() {
-		wg.Wait()
-		defer close(editionsCh)
-	}()
+	return nil
+}
 
-	// This would be where they're inserted into the DB, but that's not relevant here.
-	// var editionCount int
-	for {
-		select {
-		case err := <-errCh:
-			fmt.Fprintln(out, err)
-		case <-doneCh:
+// streamDump reads r line by line and hands batches of lines to one
+// GoRoutine per processor, all in g. r is closed once it's fully read. A
+// parser that is also a recordSplitter decides what counts as a line.
+func streamDump(ctx context.Context, g *errgroup.Group, r io.ReadCloser, parser Parser, recordsCh chan<- Record, errCh chan<- error) {
+	linesCh := make(chan [][]byte, 20)
+
+	split := bufio.ScanLines
+	if s, ok := parser.(recordSplitter); ok {
+		split = s.Split
+	}
+
+	g.Go(func() error {
+		defer close(linesCh)
+		defer r.Close()
+
+		return streamLines(ctx, r, split, LINEBATCHSIZE, linesCh)
+	})
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		g.Go(func() error {
+			for lines := range linesCh {
+				for _, line := range lines {
+					if err := processLine(ctx, parser, line, recordsCh, errCh); err != nil {
+						return err
+					}
+				}
+			}
 			return nil
-		}
+		})
 	}
 }
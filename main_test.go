@@ -2,15 +2,20 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
+
+	"golang.org/x/sync/errgroup"
 )
 
 // TestToIsbn13 calls the method and verifies the result.
 func TestToIsbn13(t *testing.T) {
-	book1 := &OpenLibraryEdition{"OL123M", "IA123", "819010750X", ""}
+	book1 := &OpenLibraryEdition{olid: "OL123M", ocaid: "IA123", isbn10: "819010750X"}
 	book1.toIsbn13()
 	expected := "9788190107501"
 
@@ -21,7 +26,7 @@ func TestToIsbn13(t *testing.T) {
 
 func BenchmarkRun(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		if err := runSeek("./testdata/30kTestEditions.txt", io.Discard); err != nil {
+		if err := runSeek(context.Background(), "./testdata/30kTestEditions.txt", io.Discard); err != nil {
 			b.Error(err)
 		}
 	}
@@ -30,7 +35,7 @@ func BenchmarkRun(b *testing.B) {
 func BenchmarkRunSeq(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		// if err := runSeq("/home/scott/code/reconcile/files/ol_dump_latest.txt", io.Discard); err != nil {
-		if err := runSeek("./testdata/50kTestEditions.txt", io.Discard); err != nil {
+		if err := runSeek(context.Background(), "./testdata/50kTestEditions.txt", io.Discard); err != nil {
 			b.Error(err)
 		}
 	}
@@ -55,3 +60,176 @@ func BenchmarkPureRead(b *testing.B) {
 	}
 	fmt.Println("Lines: ", lines)
 }
+
+func TestLoadRecordsErrors(t *testing.T) {
+	errDiskFull := errors.New("disk full")
+
+	// sendEditions sends editions OL1M, OL2M, ... until n are sent, or forever
+	// if n is 0, stopping if ctx is cancelled.
+	sendEditions := func(ctx context.Context, recordsCh chan<- Record, n int) error {
+		for i := 1; n == 0 || i <= n; i++ {
+			select {
+			case recordsCh <- &OpenLibraryEdition{olid: fmt.Sprintf("OL%dM", i)}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name   string
+		parse  parseFunc
+		expErr error
+	}{
+		{
+			name: "ParserFails",
+			parse: func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error {
+				g.Go(func() error {
+					if err := sendEditions(ctx, recordsCh, 3); err != nil {
+						return err
+					}
+					return errDiskFull
+				})
+				return nil
+			},
+			expErr: errDiskFull,
+		},
+		{
+			// The writer fails once more than a batch of editions has been
+			// written, and the parser never stops, so this only returns if
+			// the writer's error cancels it.
+			name: "WriterFails",
+			parse: func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error {
+				g.Go(func() error {
+					if err := sendEditions(ctx, recordsCh, 300); err != nil {
+						return err
+					}
+					select {
+					case recordsCh <- "not a record":
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+					return sendEditions(ctx, recordsCh, 0)
+				})
+				return nil
+			},
+			expErr: ErrorUnsupportedRecord,
+		},
+		{
+			name: "ParseFails",
+			parse: func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error {
+				return errDiskFull
+			},
+			expErr: errDiskFull,
+		},
+	}
+
+	backends := []struct {
+		backend  string
+		newStore func(dir string) (Store, error)
+	}{
+		{"sqlite", func(dir string) (Store, error) { return newSQLiteStore(filepath.Join(dir, "test.db") + DBOPTIONS) }},
+		{"bolt", func(dir string) (Store, error) { return newBoltStore(filepath.Join(dir, "test.bolt")) }},
+	}
+
+	for _, b := range backends {
+		for _, tc := range tests {
+			t.Run(b.backend+"/"+tc.name, func(t *testing.T) {
+				store, err := b.newStore(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer store.close()
+
+				// An earlier load, which the failed one mustn't change.
+				if err := loadRecords(context.Background(), store, newLoadRun("ol", "test"), io.Discard, func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error {
+					return sendEditions(ctx, recordsCh, 1)
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := loadRecords(context.Background(), store, newLoadRun("ol", "test"), io.Discard, tc.parse); !errors.Is(err, tc.expErr) {
+					t.Fatalf("expected %v, but got %v", tc.expErr, err)
+				}
+
+				var editions int
+				if err := store.editions(func(e *OpenLibraryEdition) error {
+					editions++
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+				if editions != 1 {
+					t.Fatalf("expected the failed load to be rolled back, but got %d editions", editions)
+				}
+
+				s, ok := store.(*sqliteStore)
+				if !ok {
+					return
+				}
+
+				// The ol view never shows a failed run, so check its rows were
+				// rolled back rather than just hidden.
+				var rows int
+				if err := s.db.QueryRow("SELECT count(*) FROM ol_all").Scan(&rows); err != nil {
+					t.Fatal(err)
+				}
+				if rows != 1 {
+					t.Fatalf("expected the failed run's rows to be rolled back, but got %d in ol_all", rows)
+				}
+
+				var indexes int
+				if err := s.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'idx_%'").Scan(&indexes); err != nil {
+					t.Fatal(err)
+				}
+				if indexes != len(loadIndexes) {
+					t.Fatalf("expected the dropped indexes to be restored, but got %d", indexes)
+				}
+			})
+		}
+	}
+}
+
+// getRecords parses inFile with parser and sends the records to recordsCh,
+// closing it at the end, for tests that check the records rather than load
+// them. It returns once doneCh is closed, or the first error.
+func getRecords(ctx context.Context, inFile string, parser Parser, out io.Writer, recordsCh chan<- Record, doneCh <-chan struct{}, errCh chan error, chunkSize int64) error {
+	return getRecordsWith(ctx, out, recordsCh, doneCh, errCh, func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error {
+		return parseDump(ctx, g, newLoadRun("test", inFile), parser, chunkSize, recordsCh, errCh)
+	})
+}
+
+// getRecordsFromReader is getRecords for a dump that can only be read as a
+// stream, such as stdin or an HTTP response body. Compressed streams are
+// detected and decompressed.
+func getRecordsFromReader(ctx context.Context, r io.Reader, parser Parser, out io.Writer, recordsCh chan<- Record, doneCh <-chan struct{}, errCh chan error) error {
+	return getRecordsWith(ctx, out, recordsCh, doneCh, errCh, func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error {
+		rc, err := decompressStream(r)
+		if err != nil {
+			return err
+		}
+
+		streamDump(ctx, g, rc, parser, recordsCh, errCh)
+		return nil
+	})
+}
+
+// getRecordsWith is getRecords with any parseFunc.
+func getRecordsWith(ctx context.Context, out io.Writer, recordsCh chan<- Record, doneCh <-chan struct{}, errCh chan error, parse parseFunc) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return runParsers(ctx, parse, recordsCh, errCh)
+	})
+	g.Go(func() error {
+		select {
+		case <-doneCh:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	_, err := waitForRecords(g, out, errCh)
+	return err
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Separators used by MARC21 binary records.
+const (
+	marcRecordTerminator  byte = 0x1D
+	marcFieldTerminator   byte = 0x1E
+	marcSubfieldDelimiter byte = 0x1F
+	marcLeaderLength           = 24
+	marcDirEntryLength         = 12
+)
+
+// MARCRecord holds the identifiers from a MARC21 catalog record that can be
+// cross-checked against Open Library editions.
+type MARCRecord struct {
+	source        string   // The file the record came from.
+	controlNumber string   // 001
+	isbns         []string // 020 $a, converted to ISBN 13.
+	lccns         []string // 010 $a, normalized.
+	oclcNumbers   []string // 035 $a with an (OCoLC) prefix, normalized.
+}
+
+// addControlField sets the MARCRecord field for a control field, if it's
+// wanted.
+func (m *MARCRecord) addControlField(tag, value string) {
+	if tag == "001" {
+		m.controlNumber = strings.TrimSpace(value)
+	}
+}
+
+// addSubfield sets the MARCRecord field for subfield code of data field tag,
+// if it's wanted.
+func (m *MARCRecord) addSubfield(tag string, code byte, value string) {
+	if code != 'a' {
+		return
+	}
+
+	switch tag {
+	case "020":
+		// Only keep ISBNs that can be compared against Open Library.
+		if isbn, _ := toComparableIsbn(value); isbn != "" {
+			m.isbns = append(m.isbns, isbn)
+		}
+
+	case "010":
+		if lccn := normalizeLccn(value); lccn != "" {
+			m.lccns = append(m.lccns, lccn)
+		}
+
+	case "035":
+		// 035 holds control numbers from many systems; only OCLC's are wanted.
+		if !strings.HasPrefix(strings.TrimSpace(value), "(OCoLC)") {
+			return
+		}
+		if oclc := normalizeOclc(value); oclc != "" {
+			m.oclcNumbers = append(m.oclcNumbers, oclc)
+		}
+	}
+}
+
+// parseMARC21 parses a single MARC21 binary record, without its record
+// terminator, into a *MARCRecord. The directory after the leader gives the
+// tag, length and offset of each field.
+func parseMARC21(data []byte, source string) (*MARCRecord, error) {
+	if len(data) < marcLeaderLength {
+		return nil, fmt.Errorf("record shorter than its leader: %w", ErrorInvalidMARC)
+	}
+
+	baseAddress, err := strconv.Atoi(string(data[12:17]))
+	if err != nil || baseAddress <= marcLeaderLength || baseAddress > len(data) {
+		return nil, fmt.Errorf("bad base address %q: %w", data[12:17], ErrorInvalidMARC)
+	}
+
+	m := MARCRecord{source: source}
+
+	// The directory ends with a field terminator just before the base address.
+	directory := data[marcLeaderLength : baseAddress-1]
+	for i := 0; i+marcDirEntryLength <= len(directory); i += marcDirEntryLength {
+		entry := directory[i : i+marcDirEntryLength]
+		tag := string(entry[:3])
+
+		// Atoi accepts a sign, so a negative length or offset must be
+		// rejected too, or slicing the field panics.
+		length, err := strconv.Atoi(string(entry[3:7]))
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("bad length for %v: %w", tag, ErrorInvalidMARC)
+		}
+
+		start, err := strconv.Atoi(string(entry[7:12]))
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("bad offset for %v: %w", tag, ErrorInvalidMARC)
+		}
+
+		start += baseAddress
+		if start+length > len(data) {
+			return nil, fmt.Errorf("%v runs past the end of the record: %w", tag, ErrorInvalidMARC)
+		}
+		field := bytes.TrimSuffix(data[start:start+length], []byte{marcFieldTerminator})
+
+		// Tags 001 to 009 are control fields, with no indicators or subfields.
+		if tag < "010" {
+			m.addControlField(tag, string(field))
+			continue
+		}
+
+		// Skip the two indicators; each subfield starts with a delimiter and
+		// a one character code.
+		if len(field) < 2 {
+			continue
+		}
+		for _, subfield := range bytes.Split(field[2:], []byte{marcSubfieldDelimiter}) {
+			if len(subfield) < 1 {
+				continue
+			}
+			m.addSubfield(tag, subfield[0], string(subfield[1:]))
+		}
+	}
+
+	return &m, nil
+}
+
+// MARCParser is the Parser for MARC21 binary files. It's also a
+// recordSplitter, since records end with a record terminator rather than a
+// newline.
+type MARCParser struct {
+	source string
+}
+
+func NewMARCParser(source string) *MARCParser {
+	return &MARCParser{source: source}
+}
+
+// Parse parses a single record with parseMARC21. Whitespace between records,
+// such as a trailing newline, is skipped.
+func (p *MARCParser) Parse(line []byte) (Record, error) {
+	line = bytes.TrimLeft(line, " \r\n")
+	if len(line) == 0 {
+		return nil, ErrorSkipLine
+	}
+
+	return parseMARC21(line, p.source)
+}
+
+// Split is a bufio.SplitFunc that returns one MARC21 record at a time.
+func (p *MARCParser) Split(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexByte(data, marcRecordTerminator); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+
+	// A final record without a terminator.
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// marcXMLRecord is a <record> element from a MARCXML file. Names are matched
+// without their namespace, so both <record> and <marc:record> work.
+type marcXMLRecord struct {
+	ControlFields []struct {
+		Tag   string `xml:"tag,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"controlfield"`
+	DataFields []struct {
+		Tag       string `xml:"tag,attr"`
+		Subfields []struct {
+			Code  string `xml:"code,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"subfield"`
+	} `xml:"datafield"`
+}
+
+// toMARCRecord converts a MARCXML record into a *MARCRecord.
+func (x *marcXMLRecord) toMARCRecord(source string) *MARCRecord {
+	m := MARCRecord{source: source}
+
+	for _, cf := range x.ControlFields {
+		m.addControlField(cf.Tag, cf.Value)
+	}
+
+	for _, df := range x.DataFields {
+		for _, sf := range df.Subfields {
+			if len(sf.Code) != 1 {
+				continue
+			}
+			m.addSubfield(df.Tag, sf.Code[0], sf.Value)
+		}
+	}
+
+	return &m
+}
+
+// isMARCXML peeks at the start of r and reports whether it's XML rather than
+// MARC21 binary, which always starts with the digits of the record length.
+func isMARCXML(r *bufio.Reader) bool {
+	start, _ := r.Peek(512)
+	start = bytes.TrimLeft(start, "\xef\xbb\xbf \t\r\n")
+	return len(start) > 0 && start[0] == '<'
+}
+
+// readMARCXML decodes each <record> element of a MARCXML file from r and sends
+// it to recordsCh as a *MARCRecord. Unlike binary MARC, the XML is decoded by
+// a single GoRoutine, which stops if ctx is cancelled.
+func readMARCXML(ctx context.Context, r io.Reader, source string, recordsCh chan<- Record) error {
+	d := xml.NewDecoder(r)
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+
+		var x marcXMLRecord
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return err
+		}
+
+		select {
+		case recordsCh <- x.toMARCRecord(source):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// marcWriter is the recordWriter for MARC records. Each identifier is a row in
+// the marc table, named isbn_13, lccn or oclc like in edition_identifier.
+type marcWriter struct {
+	identifiers *batchInserter
+}
+
+func newMARCWriter(db sqlExecer, runID int64, batchSize int) (recordWriter, error) {
+	identifiers, err := newRunInserter(db, runID, "marc", []string{"source", "control_number", "name", "value"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &marcWriter{identifiers: identifiers}, nil
+}
+
+func (w *marcWriter) add(record Record) error {
+	m, ok := record.(*MARCRecord)
+	if !ok {
+		return fmt.Errorf("%T: %w", record, ErrorUnsupportedRecord)
+	}
+
+	for _, identifier := range []struct {
+		name   string
+		values []string
+	}{
+		{"isbn_13", m.isbns},
+		{"lccn", m.lccns},
+		{"oclc", m.oclcNumbers},
+	} {
+		for _, value := range identifier.values {
+			if err := w.identifiers.add(m.source, m.controlNumber, identifier.name, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *marcWriter) close() error {
+	return w.identifiers.close()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// marcField is a field for makeMARC21. Control fields are given as is; data
+// fields are given with their indicators and subfields, e.g. "  \x1fa0141439513".
+type marcField struct {
+	tag  string
+	data string
+}
+
+// makeMARC21 builds a MARC21 binary record, including its record terminator.
+func makeMARC21(fields ...marcField) []byte {
+	var directory, data bytes.Buffer
+	for _, f := range fields {
+		fmt.Fprintf(&directory, "%s%04d%05d", f.tag, len(f.data)+1, data.Len())
+		data.WriteString(f.data)
+		data.WriteByte(marcFieldTerminator)
+	}
+	directory.WriteByte(marcFieldTerminator)
+
+	baseAddress := marcLeaderLength + directory.Len()
+	length := baseAddress + data.Len() + 1
+	leader := fmt.Sprintf("%05dnam a22%05d a 4500", length, baseAddress)
+
+	return []byte(leader + directory.String() + data.String() + string(marcRecordTerminator))
+}
+
+func TestParseMARC21(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []byte
+		expRecord *MARCRecord
+		expErr    error
+	}{
+		{
+			name: "AllFields",
+			input: makeMARC21(
+				marcField{"001", "12345"},
+				marcField{"008", "000000s2006    enk           000 1 eng d"},
+				marcField{"010", "  \x1fa   85000002 "},
+				marcField{"020", "  \x1fa0141439513 (pbk.)\x1fc$8.00"},
+				marcField{"020", "  \x1fa9780141439518"},
+				marcField{"035", "  \x1fa(OCoLC)ocm71126926"},
+				marcField{"035", "  \x1fa(DLC)85000002"},
+				marcField{"245", "10\x1faJane Eyre"},
+			),
+			expRecord: &MARCRecord{
+				source: "test.mrc", controlNumber: "12345", isbns: []string{"9780141439518", "9780141439518"},
+				lccns: []string{"85000002"}, oclcNumbers: []string{"71126926"},
+			},
+		},
+		{
+			name:      "SkipUnusableISBNs",
+			input:     makeMARC21(marcField{"001", "12346"}, marcField{"020", "  \x1fa123\x1fz0141439513"}),
+			expRecord: &MARCRecord{source: "test.mrc", controlNumber: "12346"},
+		},
+		{
+			name:   "TooShort",
+			input:  []byte("00010nam"),
+			expErr: ErrorInvalidMARC,
+		},
+		{
+			name:   "Truncated",
+			input:  makeMARC21(marcField{"001", "12347"}, marcField{"245", "10\x1faJane Eyre"})[:40],
+			expErr: ErrorInvalidMARC,
+		},
+		{
+			name:   "NegativeLength",
+			input:  bytes.Replace(makeMARC21(marcField{"001", "12348"}), []byte("001000600000"), []byte("001-00100000"), 1),
+			expErr: ErrorInvalidMARC,
+		},
+		{
+			name:   "NegativeOffset",
+			input:  bytes.Replace(makeMARC21(marcField{"001", "12349"}), []byte("001000600000"), []byte("0010006-0001"), 1),
+			expErr: ErrorInvalidMARC,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := bytes.TrimSuffix(tc.input, []byte{marcRecordTerminator})
+			record, err := parseMARC21(input, "test.mrc")
+			if tc.expErr != nil {
+				if !errors.Is(err, tc.expErr) {
+					t.Fatalf("expected %q, but got %q instead", tc.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(tc.expRecord, record) {
+				t.Fatalf("expected: %v, but got %v", tc.expRecord, record)
+			}
+		})
+	}
+}
+
+func TestGetMARCRecords(t *testing.T) {
+	var resRecords []*MARCRecord
+	recordsCh := make(chan Record)
+	doneCh := make(chan struct{})
+	errCh := make(chan error)
+	inFile := filepath.Join(t.TempDir(), "test.mrc")
+
+	// Records aren't newline delimited, but some files end with one anyway.
+	var data []byte
+	data = append(data, makeMARC21(marcField{"001", "1"}, marcField{"020", "  \x1fa0141439513"})...)
+	data = append(data, makeMARC21(marcField{"001", "2"}, marcField{"010", "  \x1fa2001012345"})...)
+	data = append(data, '\n')
+	if err := os.WriteFile(inFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	expRecords := []*MARCRecord{
+		{source: "test.mrc", controlNumber: "1", isbns: []string{"9780141439518"}},
+		{source: "test.mrc", controlNumber: "2", lccns: []string{"2001012345"}},
+	}
+
+	go func() {
+		for record := range recordsCh {
+			resRecords = append(resRecords, record.(*MARCRecord))
+		}
+		defer close(doneCh)
+	}()
+
+	if err := getRecords(context.Background(), inFile, NewMARCParser("test.mrc"), io.Discard, recordsCh, doneCh, errCh, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(resRecords, func(i, j int) bool {
+		return resRecords[i].controlNumber < resRecords[j].controlNumber
+	})
+
+	if !reflect.DeepEqual(expRecords, resRecords) {
+		t.Fatalf("expected %v, but got %v", expRecords, resRecords)
+	}
+}
+
+func TestReadMARCXML(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<marc:collection xmlns:marc="http://www.loc.gov/MARC21/slim">
+  <marc:record>
+    <marc:leader>00000nam a2200000 a 4500</marc:leader>
+    <marc:controlfield tag="001">12345</marc:controlfield>
+    <marc:datafield tag="010" ind1=" " ind2=" "><marc:subfield code="a">   85000002 </marc:subfield></marc:datafield>
+    <marc:datafield tag="020" ind1=" " ind2=" "><marc:subfield code="a">0141439513 (pbk.)</marc:subfield></marc:datafield>
+    <marc:datafield tag="035" ind1=" " ind2=" "><marc:subfield code="a">(OCoLC)ocm71126926</marc:subfield></marc:datafield>
+  </marc:record>
+  <marc:record>
+    <marc:controlfield tag="001">12346</marc:controlfield>
+  </marc:record>
+</marc:collection>`
+
+	br := bufio.NewReader(strings.NewReader(data))
+	if !isMARCXML(br) {
+		t.Fatal("expected MARCXML to be detected")
+	}
+
+	recordsCh := make(chan Record, 10)
+	if err := readMARCXML(context.Background(), br, "test.xml", recordsCh); err != nil {
+		t.Fatal(err)
+	}
+	close(recordsCh)
+
+	var resRecords []*MARCRecord
+	for record := range recordsCh {
+		resRecords = append(resRecords, record.(*MARCRecord))
+	}
+
+	expRecords := []*MARCRecord{
+		{source: "test.xml", controlNumber: "12345", isbns: []string{"9780141439518"}, lccns: []string{"85000002"}, oclcNumbers: []string{"71126926"}},
+		{source: "test.xml", controlNumber: "12346"},
+	}
+
+	if !reflect.DeepEqual(expRecords, resRecords) {
+		t.Fatalf("expected %v, but got %v", expRecords, resRecords)
+	}
+
+	if isMARCXML(bufio.NewReader(bytes.NewReader(makeMARC21(marcField{"001", "1"})))) {
+		t.Fatal("expected MARC21 binary not to be detected as MARCXML")
+	}
+}
+
+func TestMARCEdition(t *testing.T) {
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	olRecords := []Record{
+		&OpenLibraryEdition{olid: "OL001M", isbns: []string{"9780141439518"}},
+		&OpenLibraryEdition{olid: "OL002M", lccns: []string{"85-2"}},
+		&OpenLibraryEdition{olid: "OL003M", isbns: []string{"9788955565683"}},
+	}
+	marcRecords := []Record{
+		&MARCRecord{source: "test.mrc", controlNumber: "1", isbns: []string{"9780141439518"}, oclcNumbers: []string{"71126926"}},
+		&MARCRecord{source: "test.mrc", controlNumber: "2", lccns: []string{"85000002"}},
+		&MARCRecord{source: "test.mrc", controlNumber: "3", isbns: []string{"9780135043943"}},
+	}
+
+	loadTestRecords(t, db, newOLWriter, olRecords...)
+	loadTestRecords(t, db, newMARCWriter, marcRecords...)
+
+	rows, err := db.Query("SELECT control_number, edition_id, matched_on, value FROM marc_edition ORDER BY control_number")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var resMatches [][]string
+	for rows.Next() {
+		var controlNumber, editionID, matchedOn, value string
+		if err := rows.Scan(&controlNumber, &editionID, &matchedOn, &value); err != nil {
+			t.Fatal(err)
+		}
+		resMatches = append(resMatches, []string{controlNumber, editionID, matchedOn, value})
+	}
+
+	expMatches := [][]string{
+		{"1", "OL001M", "isbn_13", "9780141439518"},
+		{"2", "OL002M", "lccn", "85000002"},
+	}
+
+	if !reflect.DeepEqual(expMatches, resMatches) {
+		t.Fatalf("expected %v, but got %v", expMatches, resMatches)
+	}
+}
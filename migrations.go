@@ -0,0 +1,395 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// migration is one step in the DB's schema history. Migrations are applied in
+// order, each in its own transaction, and recorded in schema_version, so a DB
+// from any earlier version can be brought up to date.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations is the DB's schema history. Add new migrations to the end; never
+// change one that's been released.
+var migrations = []migration{
+	{1, "ol table", execMigration(`
+  CREATE TABLE IF NOT EXISTS ol (
+    id INTEGER NOT NULL PRIMARY KEY,
+    edition_id text,
+    ocaid text,
+    isbn_13 text
+  );`)},
+	{2, "tables and columns from before schema versioning", migrateUnversioned},
+	{3, "load runs", migrateRuns},
+	{4, "unique link candidate pairs", execMigration(`
+  DELETE FROM link_candidate WHERE id NOT IN (SELECT min(id) FROM link_candidate GROUP BY olid, ocaid);
+  DROP INDEX IF EXISTS idx_link_candidate_olid;
+  CREATE UNIQUE INDEX link_candidate_pair ON link_candidate (olid, ocaid);`)},
+	{5, "run modified_after", execMigration("ALTER TABLE run ADD COLUMN modified_after text;")},
+}
+
+// unversionedTables are the tables getDB created, with CREATE TABLE IF NOT
+// EXISTS, before the schema was versioned. Columns were added to them over
+// time, so a DB from then may have any of them, with any of their columns.
+var unversionedTables = []struct {
+	name    string
+	columns []string
+}{
+	{"ol", []string{
+		"edition_id text", "ocaid text", "isbn_13 text", "isbn_status text", "title text", "subtitle text",
+		"publishers text", "publish_date text", "number_of_pages integer", "languages text", "source_records text",
+		"revision integer", "last_modified text", "match_key text",
+	}},
+	{"edition_isbn", []string{"edition_id text", "isbn_13 text"}},
+	{"edition_work", []string{"edition_id text", "work_id text"}},
+	{"edition_identifier", []string{"edition_id text", "name text", "value text"}},
+	{"work", []string{"work_id text", "title text"}},
+	{"work_author", []string{"work_id text", "author_id text"}},
+	{"author", []string{"author_id text", "name text"}},
+	{"redirect", []string{"from_id text", "to_id text"}},
+	{"deletion", []string{"olid text"}},
+	{"ia", []string{
+		"identifier text", "ol_edition_id text", "ol_work_id text", "collection text", "title text",
+		"publish_date text", "publishers text", "image_count integer", "languages text", "creators text", "match_key text",
+	}},
+	{"ia_isbn", []string{"identifier text", "isbn_13 text"}},
+	{"ia_identifier", []string{"identifier text", "name text", "value text"}},
+	{"link_candidate", []string{
+		"olid text", "ocaid text", "isbn_13 text", "lccn text", "oclc text", "reason text", "disagreements text",
+		"score real", "title_score real", "year_score real", "publisher_score real", "pages_score real", "language_score real",
+	}},
+	{"marc", []string{"source text", "control_number text", "name text", "value text"}},
+}
+
+// migrateUnversioned creates any of the unversionedTables that are missing,
+// adds any missing columns to those that aren't, and creates the marc_edition
+// view.
+func migrateUnversioned(tx *sql.Tx) error {
+	for _, table := range unversionedTables {
+		if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS " + table.name + " (id INTEGER NOT NULL PRIMARY KEY)"); err != nil {
+			return err
+		}
+
+		if err := addMissingColumns(tx, table.name, table.columns); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(marcEditionView)
+	return err
+}
+
+// marcEditionView joins MARC records to OL editions by ISBN and LCCN.
+const marcEditionView = `
+  CREATE VIEW IF NOT EXISTS marc_edition AS
+    SELECT m.source, m.control_number, ei.edition_id, m.name AS matched_on, m.value
+    FROM marc m JOIN edition_isbn ei ON m.name = 'isbn_13' AND ei.isbn_13 = m.value
+    UNION ALL
+    SELECT m.source, m.control_number, eid.edition_id, m.name, m.value
+    FROM marc m JOIN edition_identifier eid ON m.name = 'lccn' AND eid.name = 'lccn' AND eid.value = m.value;`
+
+// migrateRuns creates the run table and moves each of the runKinds' tables to
+// <table>_all, tagged with run_id, leaving a view of the latest complete run
+// in its place. Rows already loaded are put in one complete run per kind.
+func migrateRuns(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+  CREATE TABLE run (
+    id INTEGER NOT NULL PRIMARY KEY,
+    kind text,
+    dump_file text,
+    dump_size integer,
+    dump_sha256 text,
+    dump_date text,
+    started_at text,
+    finished_at text,
+    status text,
+    errors integer,
+    error text
+  );
+  CREATE TABLE run_rows (
+    id INTEGER NOT NULL PRIMARY KEY,
+    run_id integer,
+    table_name text,
+    rows integer
+  );`); err != nil {
+		return err
+	}
+
+	// Renaming a table rewrites the views that use it, so marc_edition is
+	// created again once the new views are in place. The indexes are created
+	// again with run_id.
+	if _, err := tx.Exec("DROP VIEW IF EXISTS marc_edition"); err != nil {
+		return err
+	}
+	if err := dropIndexes(tx, ""); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, k := range runKinds {
+		var loaded bool
+		for _, table := range k.tables {
+			if _, err := tx.Exec("ALTER TABLE " + table + " RENAME TO " + table + "_all"); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("ALTER TABLE " + table + "_all ADD COLUMN run_id integer"); err != nil {
+				return err
+			}
+
+			if !loaded {
+				if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM " + table + "_all)").Scan(&loaded); err != nil {
+					return err
+				}
+			}
+		}
+
+		if loaded {
+			res, err := tx.Exec(
+				"INSERT INTO run (kind, dump_file, started_at, finished_at, status, errors) VALUES (?, '', ?, ?, ?, 0)",
+				k.kind, now, now, RunComplete,
+			)
+			if err != nil {
+				return err
+			}
+			runID, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+
+			for _, table := range k.tables {
+				if _, err := tx.Exec("UPDATE "+table+"_all SET run_id = ?", runID); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(
+					"INSERT INTO run_rows (run_id, table_name, rows) SELECT ?1, ?2, count(*) FROM "+table+"_all",
+					runID, table,
+				); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, table := range k.tables {
+			if _, err := tx.Exec(fmt.Sprintf(`
+  CREATE VIEW %[1]s AS
+    SELECT * FROM %[1]s_all
+    WHERE run_id = (SELECT max(id) FROM run WHERE kind = '%[2]s' AND status = 'complete');`, table, k.kind)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(marcEditionView); err != nil {
+		return err
+	}
+
+	return createIndexes(tx, "")
+}
+
+// addMissingColumns adds the columns, each a name and type such as
+// "title text", that table doesn't have.
+func addMissingColumns(tx *sql.Tx, table string, columns []string) error {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		name, _, _ := strings.Cut(column, " ")
+		if existing[name] {
+			continue
+		}
+
+		if _, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// execMigration returns a migration's up function that runs statements.
+func execMigration(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// getSchemaVersion returns the version of the last migration applied to db,
+// or 0 for a new or unversioned DB.
+func getSchemaVersion(db *sql.DB) (int, error) {
+	if _, err := db.Exec(`
+  CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER NOT NULL PRIMARY KEY,
+    description text,
+    applied_at text
+  );`); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.QueryRow("SELECT coalesce(max(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// migrate applies the migrations db doesn't have yet.
+func migrate(db *sql.DB) error {
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration applies m and records it in schema_version, or neither.
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)",
+		m.version, m.description, time.Now().UTC().Format(time.RFC3339),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// loadIndexes are the indexes the reports' joins and lookups need. A load
+// drops them, inserts, then builds them again, as SQLite inserts into a table
+// without indexes much faster, and building an index in one go is quicker
+// than growing it a row at a time. The views of the runKinds' tables always
+// filter on run_id, so their indexes start with it.
+var loadIndexes = []loadIndex{
+	{"ol_edition_id", "ol_all", "run_id, edition_id"},
+	{"ol_ocaid", "ol_all", "run_id, ocaid"},
+	{"ol_isbn_13", "ol_all", "run_id, isbn_13"},
+	{"ol_match_key", "ol_all", "run_id, match_key"},
+	{"edition_isbn_isbn_13", "edition_isbn_all", "run_id, isbn_13"},
+	{"edition_isbn_edition_id", "edition_isbn_all", "run_id, edition_id"},
+	{"edition_work_edition_id", "edition_work_all", "run_id, edition_id"},
+	{"edition_identifier_value", "edition_identifier_all", "run_id, name, value"},
+	{"edition_identifier_edition_id", "edition_identifier_all", "run_id, edition_id"},
+	{"work_work_id", "work_all", "run_id, work_id"},
+	{"work_author_work_id", "work_author_all", "run_id, work_id"},
+	{"author_author_id", "author_all", "run_id, author_id"},
+	{"redirect_from_id", "redirect_all", "run_id, from_id"},
+	{"deletion_olid", "deletion_all", "run_id, olid"},
+	{"ia_identifier", "ia_all", "run_id, identifier"},
+	{"ia_ol_edition_id", "ia_all", "run_id, ol_edition_id"},
+	{"ia_match_key", "ia_all", "run_id, match_key"},
+	{"ia_isbn_isbn_13", "ia_isbn_all", "run_id, isbn_13"},
+	{"ia_isbn_identifier", "ia_isbn_all", "run_id, identifier"},
+	{"ia_identifier_value", "ia_identifier_all", "run_id, name, value"},
+	{"ia_identifier_identifier", "ia_identifier_all", "run_id, identifier"},
+	{"marc_value", "marc_all", "run_id, name, value"},
+}
+
+// loadIndex is an index named idx_<name> on columns of table.
+type loadIndex struct {
+	name    string
+	table   string
+	columns string
+}
+
+// kindIndexes returns the loadIndexes on the tables a run of kind loads into,
+// or all of them if kind is empty.
+func kindIndexes(kind string) []loadIndex {
+	tables := make(map[string]bool)
+	for _, table := range runKindTables(kind) {
+		tables[table+"_all"] = true
+	}
+
+	var indexes []loadIndex
+	for _, index := range loadIndexes {
+		if kind == "" || tables[index.table] {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
+// dropIndexes drops the loadIndexes on kind's tables, or all of them if kind
+// is empty, before a bulk load. The other kinds' tables aren't being loaded,
+// so their indexes are kept.
+func dropIndexes(db sqlExecer, kind string) error {
+	for _, index := range kindIndexes(kind) {
+		if _, err := db.Exec("DROP INDEX IF EXISTS idx_" + index.name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createIndexes builds any of the loadIndexes on kind's tables, or on every
+// table if kind is empty, that are missing, then updates the query planner's
+// statistics for them.
+func createIndexes(db sqlExecer, kind string) error {
+	for _, index := range kindIndexes(kind) {
+		if _, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s ON %s (%s)", index.name, index.table, index.columns)); err != nil {
+			return err
+		}
+	}
+
+	return analyzeIndexes(db, kind)
+}
+
+// analyzeIndexes updates the query planner's statistics for kind's tables, or
+// for every table if kind is empty.
+func analyzeIndexes(db sqlExecer, kind string) error {
+	if kind == "" {
+		_, err := db.Exec("ANALYZE")
+		return err
+	}
+
+	for _, table := range runKindTables(kind) {
+		if _, err := db.Exec("ANALYZE " + table + "_all"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
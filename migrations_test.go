@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup string // An earlier schema to start from.
+	}{
+		{name: "NewDB"},
+		{
+			// The schema at the first release.
+			name:  "Baseline",
+			setup: "CREATE TABLE ol (id INTEGER NOT NULL PRIMARY KEY, edition_id text, ocaid text, isbn_13 text);",
+		},
+		{
+			// An unversioned DB, from before ia gained its scoring columns.
+			name: "Unversioned",
+			setup: `CREATE TABLE ol (id INTEGER NOT NULL PRIMARY KEY, edition_id text, ocaid text, isbn_13 text, title text);
+			  CREATE TABLE ia (id INTEGER NOT NULL PRIMARY KEY, identifier text, ol_edition_id text, ol_work_id text, collection text);
+			  INSERT INTO ia (identifier, ol_edition_id) VALUES ('IA001', 'OL001M');`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dbFile := filepath.Join(t.TempDir(), "test.db")
+			if tc.setup != "" {
+				db, err := sql.Open("sqlite3", dbFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.Exec(tc.setup); err != nil {
+					t.Fatal(err)
+				}
+				db.Close()
+			}
+
+			// Migrating twice is the same as once.
+			for i := 0; i < 2; i++ {
+				db, err := getDB(dbFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				version, err := getSchemaVersion(db)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exp := migrations[len(migrations)-1].version; version != exp {
+					t.Fatalf("expected version %d, but got %d", exp, version)
+				}
+
+				for _, table := range unversionedTables {
+					// The runKinds' tables are now views, with run_id.
+					expColumns := table.columns
+					for _, k := range runKinds {
+						for _, name := range k.tables {
+							if name == table.name {
+								expColumns = append(expColumns[:len(expColumns):len(expColumns)], "run_id integer")
+							}
+						}
+					}
+
+					var columns []string
+					rows, err := db.Query("SELECT name || ' ' || lower(type) FROM pragma_table_info(?) WHERE name != 'id' ORDER BY cid", table.name)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for rows.Next() {
+						var column string
+						if err := rows.Scan(&column); err != nil {
+							t.Fatal(err)
+						}
+						columns = append(columns, column)
+					}
+					rows.Close()
+
+					// Added columns come last, so compare them as sets.
+					if !reflect.DeepEqual(toSet(expColumns), toSet(columns)) {
+						t.Fatalf("%v: expected columns %v, but got %v", table.name, expColumns, columns)
+					}
+				}
+
+				// Existing rows are kept, in a complete run of their kind.
+				if tc.name == "Unversioned" {
+					var olid string
+					if err := db.QueryRow("SELECT ol_edition_id FROM ia WHERE identifier = 'IA001'").Scan(&olid); err != nil {
+						t.Fatal(err)
+					}
+
+					var runs string
+					if err := db.QueryRow("SELECT group_concat(kind || ' ' || status) FROM run").Scan(&runs); err != nil {
+						t.Fatal(err)
+					}
+					if exp := "ia complete"; runs != exp {
+						t.Fatalf("expected runs %q, but got %q", exp, runs)
+					}
+				}
+
+				db.Close()
+			}
+		})
+	}
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func TestCreateIndexes(t *testing.T) {
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	countIndexes := func() int {
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'idx_%'").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	if err := createIndexes(db, ""); err != nil {
+		t.Fatal(err)
+	}
+	if count := countIndexes(); count != len(loadIndexes) {
+		t.Fatalf("expected %d indexes, but got %d", len(loadIndexes), count)
+	}
+
+	var plan string
+	var id, parent, notUsed int
+	if err := db.QueryRow("EXPLAIN QUERY PLAN SELECT edition_id FROM edition_isbn WHERE isbn_13 = '9780141439518'").Scan(&id, &parent, &notUsed, &plan); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "SEARCH edition_isbn_all USING INDEX idx_edition_isbn_isbn_13 (run_id=? AND isbn_13=?)"; plan != exp {
+		t.Fatalf("expected plan %q, but got %q", exp, plan)
+	}
+
+	// Dropping an IA load's indexes leaves the rest.
+	if err := dropIndexes(db, "ia"); err != nil {
+		t.Fatal(err)
+	}
+	if count, exp := countIndexes(), len(loadIndexes)-7; count != exp {
+		t.Fatalf("expected %d indexes, but got %d", exp, count)
+	}
+	if err := createIndexes(db, "ia"); err != nil {
+		t.Fatal(err)
+	}
+	if count := countIndexes(); count != len(loadIndexes) {
+		t.Fatalf("expected %d indexes, but got %d", len(loadIndexes), count)
+	}
+
+	if err := dropIndexes(db, ""); err != nil {
+		t.Fatal(err)
+	}
+	if count := countIndexes(); count != 0 {
+		t.Fatalf("expected no indexes, but got %d", count)
+	}
+}
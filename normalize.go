@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// leadingArticles are dropped from the start of titles, so "The Hobbit" and
+// "Hobbit" block together.
+var leadingArticles = map[string]bool{
+	"the": true, "a": true, "an": true,
+	"le": true, "la": true, "les": true, "l": true,
+	"der": true, "die": true, "das": true,
+	"el": true, "los": true, "las": true,
+}
+
+// foldWords lower cases s, strips diacritics, and returns the words in it,
+// splitting on anything that isn't a letter or digit. "Brontë, Charlotte"
+// becomes [bronte charlotte].
+func foldWords(s string) []string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		// Diacritics are separate marks once decomposed.
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return strings.FieldsFunc(b.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalizeTitle normalizes a title for matching: anything after a subtitle
+// separator (: ; /) is dropped, as IA titles often include the subtitle, then
+// case, punctuation, diacritics and a leading article are removed.
+// "The Mayor of Casterbridge : a story" becomes "mayor of casterbridge".
+func normalizeTitle(title string) string {
+	if i := strings.IndexAny(title, ":;/"); i >= 0 {
+		title = title[:i]
+	}
+
+	words := foldWords(title)
+	if len(words) > 1 && leadingArticles[words[0]] {
+		words = words[1:]
+	}
+
+	return strings.Join(words, " ")
+}
+
+// normalizeAuthor normalizes an author's name for matching. Dates are dropped
+// and the words are sorted, so "Brontë, Charlotte, 1816-1855" and "Charlotte
+// Bronte" both become "bronte charlotte".
+func normalizeAuthor(name string) string {
+	var words []string
+	for _, word := range foldWords(name) {
+		if _, err := strconv.Atoi(word); err == nil {
+			continue
+		}
+		words = append(words, word)
+	}
+
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// getMatchKey returns the key editions and items are blocked on for matching
+// without ISBNs: the normalized title and the publish year, e.g. "jane eyre|1847".
+// Without both, it returns "".
+func getMatchKey(title, date string) string {
+	title = normalizeTitle(title)
+	year := getYear(date)
+	if title == "" || year == 0 {
+		return ""
+	}
+
+	return title + "|" + strconv.Itoa(year)
+}
+
+// authorsAgree reports whether any of the ; separated OL author names matches
+// any of the ; separated IA creators. Names match if the words of one are all
+// in the other, allowing for middle names and dates. A side with no authors
+// can't show they're the same book, so it never agrees.
+func authorsAgree(olAuthors, iaCreators string) bool {
+	if olAuthors == "" || iaCreators == "" {
+		return false
+	}
+
+	for _, olAuthor := range strings.Split(olAuthors, ";") {
+		olWords := strings.Fields(normalizeAuthor(olAuthor))
+		for _, iaCreator := range strings.Split(iaCreators, ";") {
+			iaWords := strings.Fields(normalizeAuthor(iaCreator))
+			if isSubset(olWords, iaWords) || isSubset(iaWords, olWords) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isSubset reports whether every word in a is in b. An empty a is not.
+func isSubset(a, b []string) bool {
+	if len(a) == 0 {
+		return false
+	}
+
+	words := map[string]bool{}
+	for _, word := range b {
+		words[word] = true
+	}
+
+	for _, word := range a {
+		if !words[word] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		exp   string
+	}{
+		{title: "Jane Eyre", exp: "jane eyre"},
+		{title: "The Mayor of Casterbridge : a story of a man of character", exp: "mayor of casterbridge"},
+		{title: "Les Misérables", exp: "miserables"},
+		{title: "L'Étranger", exp: "etranger"},
+		{title: "Poems / by Emily Dickinson", exp: "poems"},
+		{title: "The", exp: "the"},
+		{title: "", exp: ""},
+	}
+
+	for _, tc := range tests {
+		if res := normalizeTitle(tc.title); res != tc.exp {
+			t.Fatalf("%q: expected %q, but got %q", tc.title, tc.exp, res)
+		}
+	}
+}
+
+func TestNormalizeAuthor(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  string
+	}{
+		{name: "Charlotte Brontë", exp: "bronte charlotte"},
+		{name: "Brontë, Charlotte, 1816-1855", exp: "bronte charlotte"},
+		{name: "Hugo, Victor, 1802-1885.", exp: "hugo victor"},
+	}
+
+	for _, tc := range tests {
+		if res := normalizeAuthor(tc.name); res != tc.exp {
+			t.Fatalf("%q: expected %q, but got %q", tc.name, tc.exp, res)
+		}
+	}
+}
+
+func TestGetMatchKey(t *testing.T) {
+	tests := []struct {
+		title string
+		date  string
+		exp   string
+	}{
+		{title: "The Mayor of Casterbridge", date: "1886", exp: "mayor of casterbridge|1886"},
+		{title: "Mayor of Casterbridge : a story", date: "May 1886", exp: "mayor of casterbridge|1886"},
+		{title: "Jane Eyre", date: "", exp: ""},
+		{title: "", date: "1847", exp: ""},
+	}
+
+	for _, tc := range tests {
+		if res := getMatchKey(tc.title, tc.date); res != tc.exp {
+			t.Fatalf("%q, %q: expected %q, but got %q", tc.title, tc.date, tc.exp, res)
+		}
+	}
+}
+
+func TestAuthorsAgree(t *testing.T) {
+	tests := []struct {
+		olAuthors  string
+		iaCreators string
+		exp        bool
+	}{
+		{olAuthors: "Thomas Hardy", iaCreators: "Hardy, Thomas, 1840-1928", exp: true},
+		{olAuthors: "Charlotte Brontë", iaCreators: "Bronte, Charlotte Mary", exp: true},
+		{olAuthors: "Thomas Hardy;Charles Dickens", iaCreators: "Dickens, Charles", exp: true},
+		{olAuthors: "Thomas Hardy", iaCreators: "Dickens, Charles", exp: false},
+		{olAuthors: "", iaCreators: "Dickens, Charles", exp: false},
+		{olAuthors: "Thomas Hardy", iaCreators: "", exp: false},
+		{olAuthors: "", iaCreators: "", exp: false},
+	}
+
+	for _, tc := range tests {
+		if res := authorsAgree(tc.olAuthors, tc.iaCreators); res != tc.exp {
+			t.Fatalf("%q, %q: expected %v, but got %v", tc.olAuthors, tc.iaCreators, tc.exp, res)
+		}
+	}
+}
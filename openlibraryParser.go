@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/buger/jsonparser"
 	_ "github.com/mattn/go-sqlite3" // See http://go-database-sql.org/importing.html for an explanation of this side effect.
@@ -14,15 +17,71 @@ import (
 // - run unmasher method on OpenLibraryEdition struct.
 // - send struct to channel.
 
-const PREFIX string = "978"
+// OpenLibraryRecord is an edition, work or author parsed from the Open
+// Library dump.
+type OpenLibraryRecord interface {
+	getOlid() string
+}
 
 type OpenLibraryEdition struct {
-	olid   string
-	ocaid  string
-	isbn10 string
-	isbn13 string
+	olid       string
+	ocaid      string
+	isbn10     string     // The first ISBN 10.
+	isbn13     string     // The first usable ISBN 13, or the first ISBN 10 converted.
+	isbns      []string   // Every usable ISBN 13, and every ISBN 10 converted to 13.
+	isbnStatus IsbnStatus // Status of isbn13, or of the first ISBN if none are usable.
+	works      []string   // OLIDs of the works this is an edition of.
+
+	// From the revision and last_modified dump columns.
+	revision     int
+	lastModified time.Time
+
+	// Optional fields, only set when selected in editionFields.
+	title         string
+	subtitle      string
+	publishers    []string
+	publishDate   string
+	numberOfPages int
+	languages     []string // Language codes, e.g. eng.
+	lccns         []string
+	oclcNumbers   []string
+	sourceRecords []string            // E.g. marc:..., ia:...
+	identifiers   map[string][]string // Other identifiers, e.g. goodreads.
+}
+
+func (o *OpenLibraryEdition) getOlid() string { return o.olid }
+
+type OpenLibraryWork struct {
+	olid    string
+	title   string
+	authors []string // Author OLIDs.
+}
+
+func (w *OpenLibraryWork) getOlid() string { return w.olid }
+
+type OpenLibraryAuthor struct {
+	olid string
+	name string
+}
+
+func (a *OpenLibraryAuthor) getOlid() string { return a.olid }
+
+// OpenLibraryRedirect is a record, usually from a merge, that now points to
+// location.
+type OpenLibraryRedirect struct {
+	olid     string
+	location string // OLID of the record this redirects to.
 }
 
+func (r *OpenLibraryRedirect) getOlid() string { return r.olid }
+
+// OpenLibraryDeletion is a record that has been deleted.
+type OpenLibraryDeletion struct {
+	olid string
+}
+
+func (d *OpenLibraryDeletion) getOlid() string { return d.olid }
+
 func NewOpenLibraryEdition(olid, ocaid, isbn10, isbn13 string) *OpenLibraryEdition {
 	return &OpenLibraryEdition{
 		olid:   olid,
@@ -38,31 +97,87 @@ var paths = [][]string{
 	{"ocaid"},
 	{"isbn_10"},
 	{"isbn_13"},
+	{"works"},
+	{"title"},
+	{"subtitle"},
+	{"publishers"},
+	{"publish_date"},
+	{"number_of_pages"},
+	{"languages"},
+	{"lccn"},
+	{"oclc_numbers"},
+	{"source_records"},
+	{"identifiers"},
 }
 
-// toIsbn13 converts an *OpenLibraryEdition isbn10 to ISBN 13 and sets isbn13.
-func (o *OpenLibraryEdition) toIsbn13() error {
-	// Set ISBNs that aren't 10 characters to 0000000000 for easy identification.
-	if len(o.isbn10) != 10 {
-		o.isbn10 = "0000000000"
-	}
+// workPaths and authorPaths are paths for works and authors, respectively.
+var workPaths = [][]string{
+	{"key"},
+	{"title"},
+	{"authors"},
+}
 
-	firstNine := o.isbn10[:9]
-	firstTwelve := PREFIX + firstNine
+var authorPaths = [][]string{
+	{"key"},
+	{"name"},
+}
 
-	checkDigit, err := getIsbn13CheckDigit(firstTwelve)
+var redirectPaths = [][]string{
+	{"key"},
+	{"location"},
+}
+
+// toIsbn13 converts an *OpenLibraryEdition isbn10 to ISBN 13 and sets isbn13.
+func (o *OpenLibraryEdition) toIsbn13() error {
+	isbn13, err := isbn10To13(o.isbn10)
 	if err != nil {
 		return err
 	}
 
-	o.isbn13 = firstTwelve + checkDigit
+	o.isbn13 = isbn13
 
 	return nil
 }
 
+// setIsbns normalizes and classifies the ISBNs from the edition's isbn_10 and
+// isbn_13 fields, then sets isbns to every usable ISBN 13 followed by every
+// usable ISBN 10 converted to 13, without duplicates. isbn13 is the first of
+// these. Unusable ISBNs are left out and only show up in isbnStatus.
+func (o *OpenLibraryEdition) setIsbns(isbn10s, isbn13s []string) {
+	if len(isbn10s) > 0 {
+		o.isbn10 = normalizeIsbn(isbn10s[0])
+	}
+
+	seen := make(map[string]bool, len(isbn10s)+len(isbn13s))
+	firstStatus := IsbnNone
+	for _, raw := range append(isbn13s, isbn10s...) {
+		isbn, status := toComparableIsbn(raw)
+		if firstStatus == IsbnNone {
+			firstStatus = status
+		}
+
+		if isbn == "" || seen[isbn] {
+			continue
+		}
+		seen[isbn] = true
+
+		if len(o.isbns) == 0 {
+			o.isbn13 = isbn
+			o.isbnStatus = status
+		}
+		o.isbns = append(o.isbns, isbn)
+	}
+
+	if len(o.isbns) == 0 {
+		o.isbnStatus = firstStatus
+	}
+}
+
 // Unmartial JSON data from the Open Library dump into an *OpenLibraryEdition.
-func (o *OpenLibraryEdition) unmartialJSON(jsonData []byte) error {
+// Only the optional fields in fields are set.
+func (o *OpenLibraryEdition) unmartialJSON(jsonData []byte, fields EditionFields) error {
 	var innerErr error
+	var isbn10s, isbn13s []string
 	jsonparser.EachKey(jsonData, func(i int, v []byte, vt jsonparser.ValueType, err error) {
 		if err != nil {
 			return
@@ -90,28 +205,180 @@ func (o *OpenLibraryEdition) unmartialJSON(jsonData []byte) error {
 			}
 
 		case 2: // isbn_10
-			o.isbn10, err = getFirstIsbnFromArray(v)
+			isbn10s, err = getIsbnsFromArray(v)
 			if err != nil {
 				innerErr = err
 				return
 			}
 
 		case 3: // isbn_13
-			o.isbn13, err = getFirstIsbnFromArray(v)
+			isbn13s, err = getIsbnsFromArray(v)
+			if err != nil {
+				innerErr = err
+				return
+			}
+
+		case 4: // works
+			o.works, err = getOlidsFromArray(v)
 			if err != nil {
 				innerErr = err
 				return
 			}
 		}
-	}, paths...)
 
-	// If there's an ISBN 13 and no ISBN 13, try to convert 10 to 13.
-	if o.isbn13 == "" && o.isbn10 != "" {
-		if err := o.toIsbn13(); err != nil {
-			return err
+		// Everything after works is optional.
+		if i < 5 || !fields[paths[i][0]] {
+			return
+		}
+
+		if err := o.setOptionalField(i, v, vt); err != nil {
+			innerErr = err
 		}
+	}, paths...)
+
+	o.setIsbns(isbn10s, isbn13s)
+
+	return innerErr
+}
+
+// setOptionalField sets the optional field at paths[i] from its JSON value.
+func (o *OpenLibraryEdition) setOptionalField(i int, v []byte, vt jsonparser.ValueType) error {
+	var err error
+
+	switch i {
+	case 5: // title
+		o.title, err = jsonparser.ParseString(v)
+
+	case 6: // subtitle
+		o.subtitle, err = jsonparser.ParseString(v)
+
+	case 7: // publishers
+		o.publishers, err = getStringsFromValue(v, vt)
+
+	case 8: // publish_date
+		o.publishDate, err = jsonparser.ParseString(v)
+
+	case 9: // number_of_pages
+		o.numberOfPages, err = getIntFromValue(v, vt)
+
+	case 10: // languages
+		o.languages, err = getOlidsFromArray(v)
+
+	case 11: // lccn
+		o.lccns, err = getStringsFromValue(v, vt)
+
+	case 12: // oclc_numbers
+		o.oclcNumbers, err = getStringsFromValue(v, vt)
+
+	case 13: // source_records
+		o.sourceRecords, err = getStringsFromValue(v, vt)
+
+	case 14: // identifiers
+		o.identifiers, err = getIdentifiersFromObject(v)
 	}
 
+	return err
+}
+
+// Unmartial JSON data from the Open Library dump into an *OpenLibraryWork.
+func (w *OpenLibraryWork) unmartialJSON(jsonData []byte) error {
+	var innerErr error
+	jsonparser.EachKey(jsonData, func(i int, v []byte, vt jsonparser.ValueType, err error) {
+		if err != nil {
+			return
+		}
+
+		if vt == jsonparser.Null || vt == jsonparser.NotExist {
+			return
+		}
+
+		switch i {
+		case 0: // key
+			key, err := jsonparser.ParseString(v)
+			if err != nil {
+				innerErr = err
+				return
+			}
+			w.olid = getOlidFromKey(key)
+
+		case 1: // title
+			w.title, err = jsonparser.ParseString(v)
+			if err != nil {
+				innerErr = err
+				return
+			}
+
+		case 2: // authors
+			w.authors, err = getAuthorOlidsFromArray(v)
+			if err != nil {
+				innerErr = err
+				return
+			}
+		}
+	}, workPaths...)
+
+	return innerErr
+}
+
+// Unmartial JSON data from the Open Library dump into an *OpenLibraryAuthor.
+func (a *OpenLibraryAuthor) unmartialJSON(jsonData []byte) error {
+	var innerErr error
+	jsonparser.EachKey(jsonData, func(i int, v []byte, vt jsonparser.ValueType, err error) {
+		if err != nil {
+			return
+		}
+
+		if vt == jsonparser.Null || vt == jsonparser.NotExist {
+			return
+		}
+
+		switch i {
+		case 0: // key
+			key, err := jsonparser.ParseString(v)
+			if err != nil {
+				innerErr = err
+				return
+			}
+			a.olid = getOlidFromKey(key)
+
+		case 1: // name
+			a.name, err = jsonparser.ParseString(v)
+			if err != nil {
+				innerErr = err
+				return
+			}
+		}
+	}, authorPaths...)
+
+	return innerErr
+}
+
+// Unmartial JSON data from the Open Library dump into an *OpenLibraryRedirect.
+func (r *OpenLibraryRedirect) unmartialJSON(jsonData []byte) error {
+	var innerErr error
+	jsonparser.EachKey(jsonData, func(i int, v []byte, vt jsonparser.ValueType, err error) {
+		if err != nil {
+			return
+		}
+
+		if vt == jsonparser.Null || vt == jsonparser.NotExist {
+			return
+		}
+
+		key, err := jsonparser.ParseString(v)
+		if err != nil {
+			innerErr = err
+			return
+		}
+
+		switch i {
+		case 0: // key
+			r.olid = getOlidFromKey(key)
+		case 1: // location
+			r.location = getOlidFromKey(key)
+		}
+	}, redirectPaths...)
+
 	return innerErr
 }
 
@@ -121,37 +388,127 @@ func getOlidFromKey(key string) string {
 	return v[(len(v) - 1)]
 }
 
+// OpenLibraryParser is the Parser for the Open Library dump.
+type OpenLibraryParser struct {
+	fields        EditionFields // Optional edition fields to parse.
+	modifiedAfter time.Time     // Skip editions last modified at or before this. Zero keeps them all.
+}
+
+func NewOpenLibraryParser(fields EditionFields, modifiedAfter time.Time) *OpenLibraryParser {
+	return &OpenLibraryParser{fields: fields, modifiedAfter: modifiedAfter}
+}
+
+// Parse parses a line with parseOLLine, skipping unsupported record types and
+// editions that haven't been modified since modifiedAfter.
+func (p *OpenLibraryParser) Parse(line []byte) (Record, error) {
+	record, err := parseOLLine(line, p.fields)
+	if err != nil {
+		if errors.Is(err, ErrorUnsupportedType) {
+			return nil, ErrorSkipLine
+		}
+		return nil, err
+	}
+
+	if edition, ok := record.(*OpenLibraryEdition); ok && !p.modifiedAfter.IsZero() && !edition.lastModified.After(p.modifiedAfter) {
+		return nil, ErrorSkipLine
+	}
+
+	return record, nil
+}
+
 // parseOLLine() reads a line from the Open Library dump, parses it, and
-// returns an *OpenLibraryEdition with edition data.
-func parseOLLine(line []byte) (*OpenLibraryEdition, error) {
+// returns an *OpenLibraryEdition, *OpenLibraryWork, *OpenLibraryAuthor,
+// *OpenLibraryRedirect or *OpenLibraryDeletion. Other record types return
+// ErrorUnsupportedType. Editions only get the optional fields in fields.
+func parseOLLine(line []byte, fields EditionFields) (OpenLibraryRecord, error) {
 	columns := bytes.Split(line, []byte("\t"))
 	if len(columns) != 5 {
 		return nil, fmt.Errorf("%v, %w", string(columns[0]), ErrorWrongColCount)
 	}
 
-	// bytes == "/type/edition". Is assigning this here causing excess memory allocation? Is it faster defined elsewhere?
-	editionType := []byte{47, 116, 121, 112, 101, 47, 101, 100, 105, 116, 105, 111, 110}
-	if res := bytes.Compare(columns[0], editionType); res != 0 {
-		return nil, ErrorNotEdition
+	// jsonData := columns[4]
+
+	// The conversion to string in a switch doesn't allocate.
+	switch string(columns[0]) {
+	case "/type/edition":
+		o := OpenLibraryEdition{}
+		if err := o.unmartialJSON(columns[4], fields); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if o.revision, o.lastModified, err = parseRevision(columns[2], columns[3]); err != nil {
+			return nil, fmt.Errorf("%v: %w", o.olid, err)
+		}
+		return &o, nil
+
+	case "/type/work":
+		w := OpenLibraryWork{}
+		if err := w.unmartialJSON(columns[4]); err != nil {
+			return nil, err
+		}
+		return &w, nil
+
+	case "/type/author":
+		a := OpenLibraryAuthor{}
+		if err := a.unmartialJSON(columns[4]); err != nil {
+			return nil, err
+		}
+		return &a, nil
+
+	case "/type/redirect":
+		r := OpenLibraryRedirect{}
+		if err := r.unmartialJSON(columns[4]); err != nil {
+			return nil, err
+		}
+		return &r, nil
+
+	case "/type/delete":
+		// The key column is all a deletion needs.
+		return &OpenLibraryDeletion{olid: getOlidFromKey(string(columns[1]))}, nil
 	}
 
-	// jsonData := columns[4]
+	return nil, ErrorUnsupportedType
+}
 
-	o := OpenLibraryEdition{}
-	if err := o.unmartialJSON(columns[4]); err != nil {
-		return nil, err
+// parseRevision reads the revision and last_modified columns of a dump line,
+// e.g. 6 and 2020-12-22T19:20:44.396666. last_modified has no time zone, so
+// it's read as UTC.
+func parseRevision(revision, lastModified []byte) (int, time.Time, error) {
+	r, err := strconv.Atoi(string(revision))
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%q: %w", revision, ErrorBadRevision)
 	}
 
-	return &o, nil
+	t, err := time.Parse(LASTMODIFIEDLAYOUT, string(lastModified))
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%q: %w", lastModified, ErrorBadRevision)
+	}
+
+	return r, t, nil
+}
+
+// parseModifiedAfter reads the -modified-after flag, which is either a date,
+// e.g. 2023-01-31, or a full last_modified timestamp. An empty string returns
+// the zero time, which filters nothing.
+func parseModifiedAfter(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(LASTMODIFIEDLAYOUT, s)
 }
 
-// getFirstIsbnFromArray() reads a []byte of ISBNs in the form ["12345", "67890"]
-// and returns the first one.
-func getFirstIsbnFromArray(isbns []byte) (string, error) {
+// getIsbnsFromArray() reads a []byte of ISBNs in the form ["12345", "67890"]
+// and returns all of them.
+func getIsbnsFromArray(isbns []byte) ([]string, error) {
 	var parsedIsbns []string
 	var innerErr error
 
-	// This is a wastful implementation. Could just read to the first comma and strip quotes.
 	jsonparser.ArrayEach(isbns, func(element []byte, _ jsonparser.ValueType, _ int, err error) {
 		if err != nil {
 			innerErr = err
@@ -160,88 +517,292 @@ func getFirstIsbnFromArray(isbns []byte) (string, error) {
 		parsedIsbns = append(parsedIsbns, string(element))
 	})
 
-	// Test for length only once the array values are parsed from the bytes; otherwise empty errays are literally
-	// the byte values of "[" and "]", and have lengths of 2.
-	if len(parsedIsbns) <= 0 {
-		return "", nil // Not interested in logging editions with isbn_10 = [], etc.
-	}
+	return parsedIsbns, innerErr
+}
+
+// getOlidsFromArray() reads a []byte of keys in the form
+// [{"key": "/works/OL1W"}, {"key": "/works/OL2W"}] and returns the OLIDs.
+func getOlidsFromArray(keys []byte) ([]string, error) {
+	var olids []string
+	var innerErr error
 
-	return parsedIsbns[0], innerErr
+	jsonparser.ArrayEach(keys, func(element []byte, _ jsonparser.ValueType, _ int, err error) {
+		if err != nil {
+			innerErr = err
+			return
+		}
+
+		key, err := jsonparser.GetString(element, "key")
+		if err != nil {
+			return // Not interested in malformed keys.
+		}
+
+		olids = append(olids, getOlidFromKey(key))
+	})
+
+	return olids, innerErr
 }
 
-// addEditionToDBBatch uses batching for faster DB inserts.
-// Thanks to https://github.com/h12w/sqlite-benchmark/blob/master/main.go
-func addEditionToDBBatch(editionCh <-chan *OpenLibraryEdition, doneCh chan<- struct{}, db *sql.DB, batchSize int) error {
-	batch := make([]interface{}, 0, batchSize*3)
-	var batchTotal int64
+// getIntFromValue() reads a number that's occasionally stored as a string,
+// such as number_of_pages. Values that aren't a number return 0.
+func getIntFromValue(v []byte, vt jsonparser.ValueType) (int, error) {
+	switch vt {
+	case jsonparser.Number:
+		n, err := jsonparser.ParseInt(v)
+		return int(n), err
 
-	// Insert statement; preallocate bytes to save a few seconds and then
-	// concatenate a string the length of the items being inserted.
-	// 11*batchSize is a rough approximation, given 3 items + punctuation.
-	insertBeginning := make([]byte, 0, 11*batchSize)
-	bufStmtFull := bytes.NewBuffer(insertBeginning)
-	bufStmtFull.WriteString("INSERT INTO ol (edition_id, ocaid, isbn_13) VALUES ")
-	for i := 0; i < batchSize; i++ {
-		if i > 0 {
-			bufStmtFull.WriteString(",")
+	case jsonparser.String:
+		n, err := strconv.Atoi(strings.TrimSpace(string(v)))
+		if err != nil {
+			return 0, nil
 		}
-		bufStmtFull.WriteString("(?, ?, ?)")
+		return n, nil
 	}
 
-	// Prepared statement for speed increase.
-	insertStmt, err := db.Prepare(bufStmtFull.String())
+	return 0, nil
+}
+
+// getIdentifiersFromObject() reads the identifiers object, in the form
+// {"goodreads": ["123"], "librarything": ["456"]}.
+func getIdentifiersFromObject(identifiers []byte) (map[string][]string, error) {
+	parsed := map[string][]string{}
+
+	err := jsonparser.ObjectEach(identifiers, func(key []byte, v []byte, vt jsonparser.ValueType, _ int) error {
+		values, err := getStringsFromValue(v, vt)
+		if err != nil {
+			return err
+		}
+
+		if len(values) > 0 {
+			parsed[string(key)] = values
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if len(parsed) == 0 {
+		return nil, nil
 	}
+	return parsed, nil
+}
 
-	// Handle full batches first. Blocks until editionCh is closed.
-	for edition := range editionCh {
-		// Keep adding items until the batch is batchSize, then process.
-		if len(batch)/3 < batchSize {
-			batch = append(batch, edition.olid, edition.ocaid, edition.isbn13)
-			batchTotal++
+// getAuthorOlidsFromArray() reads a work's authors, in the form
+// [{"author": {"key": "/authors/OL1A"}, "type": ...}], and returns the author
+// OLIDs. Older records have {"author": "/authors/OL1A"} instead.
+func getAuthorOlidsFromArray(authors []byte) ([]string, error) {
+	var olids []string
+	var innerErr error
+
+	jsonparser.ArrayEach(authors, func(element []byte, _ jsonparser.ValueType, _ int, err error) {
+		if err != nil {
+			innerErr = err
+			return
 		}
 
-		// Insert when the batch is full
-		if len(batch)/3 == batchSize {
-			if _, err = insertStmt.Exec(batch...); err != nil {
-				return err
+		key, err := jsonparser.GetString(element, "author", "key")
+		if err != nil {
+			key, err = jsonparser.GetString(element, "author")
+			if err != nil {
+				return
 			}
+		}
+
+		olids = append(olids, getOlidFromKey(key))
+	})
+
+	return olids, innerErr
+}
+
+// olWriter is the recordWriter for the Open Library dump. It inserts, in
+// batches:
+//   - editions into ol, edition_isbn, edition_work and edition_identifier;
+//   - works into work and work_author;
+//   - authors into author;
+//   - redirects and deletions into redirect and deletion.
+type olWriter struct {
+	editions     *batchInserter
+	isbns        *batchInserter
+	editionWorks *batchInserter
+	identifiers  *batchInserter
+	works        *batchInserter
+	workAuthors  *batchInserter
+	authors      *batchInserter
+	redirects    *batchInserter
+	deletions    *batchInserter
+}
+
+func newOLWriter(db sqlExecer, runID int64, batchSize int) (recordWriter, error) {
+	var err error
+	w := &olWriter{}
+
+	w.editions, err = newRunInserter(db, runID, "ol", []string{
+		"edition_id", "ocaid", "isbn_13", "isbn_status", "title", "subtitle", "publishers",
+		"publish_date", "number_of_pages", "languages", "source_records", "revision", "last_modified",
+		"match_key",
+	}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.isbns, err = newRunInserter(db, runID, "edition_isbn", []string{"edition_id", "isbn_13"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.editionWorks, err = newRunInserter(db, runID, "edition_work", []string{"edition_id", "work_id"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
 
-			// "Reset" batch for next round.
-			batch = batch[0:0]
+	w.identifiers, err = newRunInserter(db, runID, "edition_identifier", []string{"edition_id", "name", "value"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.works, err = newRunInserter(db, runID, "work", []string{"work_id", "title"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.workAuthors, err = newRunInserter(db, runID, "work_author", []string{"work_id", "author_id"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.authors, err = newRunInserter(db, runID, "author", []string{"author_id", "name"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.redirects, err = newRunInserter(db, runID, "redirect", []string{"from_id", "to_id"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	w.deletions, err = newRunInserter(db, runID, "deletion", []string{"olid"}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *olWriter) add(record Record) error {
+	switch r := record.(type) {
+	case *OpenLibraryEdition:
+		return w.addEdition(r)
+	case *OpenLibraryWork:
+		return w.addWork(r)
+	case *OpenLibraryAuthor:
+		return w.authors.add(r.olid, r.name)
+	case *OpenLibraryRedirect:
+		return w.redirects.add(r.olid, r.location)
+	case *OpenLibraryDeletion:
+		return w.deletions.add(r.olid)
+	}
+
+	return fmt.Errorf("%T: %w", record, ErrorUnsupportedRecord)
+}
+
+func (w *olWriter) close() error {
+	for _, inserter := range []*batchInserter{
+		w.editions, w.isbns, w.editionWorks, w.identifiers, w.works, w.workAuthors, w.authors, w.redirects, w.deletions,
+	} {
+		if err := inserter.close(); err != nil {
+			return err
 		}
 	}
 
-	if err = insertStmt.Close(); err != nil {
+	return nil
+}
+
+// addEdition queues an edition's rows for ol, edition_isbn, edition_work and
+// edition_identifier. LCCNs and OCLC numbers are normalized and stored as
+// identifiers named lccn and oclc, so they can be joined with MARC records.
+func (w *olWriter) addEdition(edition *OpenLibraryEdition) error {
+	// Store unknown page counts as NULL rather than 0.
+	var numberOfPages interface{}
+	if edition.numberOfPages > 0 {
+		numberOfPages = edition.numberOfPages
+	}
+
+	// Store last_modified as it appears in the dump, so it sorts and compares
+	// as text.
+	var lastModified interface{}
+	if !edition.lastModified.IsZero() {
+		lastModified = edition.lastModified.Format(LASTMODIFIEDLAYOUT)
+	}
+
+	if err := w.editions.add(
+		edition.olid, edition.ocaid, edition.isbn13, edition.isbnStatus.String(), edition.title, edition.subtitle,
+		strings.Join(edition.publishers, ";"), edition.publishDate, numberOfPages,
+		strings.Join(edition.languages, ";"), strings.Join(edition.sourceRecords, ";"),
+		edition.revision, lastModified, getMatchKey(edition.title, edition.publishDate),
+	); err != nil {
 		return err
 	}
 
-	// With editionCh closed, it's time to handle the final, partially
-	// filled batch.
-	bufStmtFinal := bytes.NewBufferString("INSERT INTO ol (edition_id, ocaid, isbn_13) VALUES ")
-	for i := 0; i < len(batch)/3; i++ {
-		if i > 0 {
-			bufStmtFinal.WriteString(",")
+	for _, isbn := range edition.isbns {
+		if err := w.isbns.add(edition.olid, isbn); err != nil {
+			return err
 		}
-		bufStmtFinal.WriteString("(?, ?, ?)")
 	}
 
-	insertStmtFnl, err := db.Prepare(bufStmtFinal.String())
-	if err != nil {
-		return err
+	for _, work := range edition.works {
+		if err := w.editionWorks.add(edition.olid, work); err != nil {
+			return err
+		}
 	}
 
-	if _, err = insertStmtFnl.Exec(batch...); err != nil {
-		return err
+	for _, lccn := range edition.lccns {
+		if lccn = normalizeLccn(lccn); lccn == "" {
+			continue
+		}
+		if err := w.identifiers.add(edition.olid, "lccn", lccn); err != nil {
+			return err
+		}
 	}
 
-	err = insertStmtFnl.Close()
-	if err != nil {
+	for _, oclc := range edition.oclcNumbers {
+		if oclc = normalizeOclc(oclc); oclc == "" {
+			continue
+		}
+		if err := w.identifiers.add(edition.olid, "oclc", oclc); err != nil {
+			return err
+		}
+	}
+
+	// Sort the names so rows are inserted in a stable order.
+	names := make([]string, 0, len(edition.identifiers))
+	for name := range edition.identifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range edition.identifiers[name] {
+			if err := w.identifiers.add(edition.olid, name, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// addWork queues a work's rows for work and work_author.
+func (w *olWriter) addWork(work *OpenLibraryWork) error {
+	if err := w.works.add(work.olid, work.title); err != nil {
 		return err
 	}
 
-	// Close done for both getEditions and runSeek in general.
-	defer close(doneCh)
+	for _, author := range work.authors {
+		if err := w.workAuthors.add(work.olid, author); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
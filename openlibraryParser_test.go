@@ -1,57 +1,62 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3" // See http://go-database-sql.org/importing.html for an explanation of this side effect.
 )
 
 var expEditions = []*OpenLibraryEdition{
-	{"OL001M", "IA001", "", "9788955565683"},
-	{"OL002M", "IA002", "0135043948", "9780135043943"},
-	{"OL16775850M", "seals0000bekk", "", "9781590368930"},
+	{olid: "OL001M", ocaid: "IA001", isbn13: "9788955565683", isbns: []string{"9788955565683"}, isbnStatus: IsbnValid},
+	{olid: "OL002M", ocaid: "IA002", isbn10: "0135043948", isbn13: "9780135043943", isbns: []string{"9780135043943"}, isbnStatus: IsbnValid},
+	{olid: "OL16775850M", ocaid: "seals0000bekk", isbn13: "9781590368930", isbns: []string{"9781590368930"}, isbnStatus: IsbnValid},
 }
 
+// testLastModified is the last_modified column used in TestParseOLLine.
+var testLastModified = time.Date(2020, 12, 22, 19, 20, 44, 396666000, time.UTC)
+
 func TestParseOLLine(t *testing.T) {
 	tests := []struct {
 		name       string
 		input      string
-		expEdition *OpenLibraryEdition
+		expEdition OpenLibraryRecord
 		expErr     error
 	}{
 		{
 			name: "ISBN13", input: `/type/edition	/books/OL001M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL001M", "isbn_13": ["9788955565683"], "ocaid": "IA001"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL001M", ocaid: "IA001", isbn10: "", isbn13: "9788955565683"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL001M", revision: 6, lastModified: testLastModified, ocaid: "IA001", isbn10: "", isbn13: "9788955565683", isbns: []string{"9788955565683"}, isbnStatus: IsbnValid}, expErr: nil,
 		},
 		{
 			name: "ISBN10", input: `/type/edition	/books/OL002M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL002M", "isbn_10": ["0141439513"], "ocaid": "IA002"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL002M", ocaid: "IA002", isbn10: "0141439513", isbn13: "9780141439518"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL002M", revision: 6, lastModified: testLastModified, ocaid: "IA002", isbn10: "0141439513", isbn13: "9780141439518", isbns: []string{"9780141439518"}, isbnStatus: IsbnValid}, expErr: nil,
 		},
-		// This invalid ISBN 10 produces an invalid ISBN 13. That does not currently matter for our comparison purposes.
+		// This invalid ISBN 10 still produces an ISBN 13 for comparison purposes, but its status records the bad checksum.
 		{
 			name: "BadISBN10", input: `/type/edition	/books/OL003M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL003M", "isbn_10": ["222222222X"], "ocaid": "IA003"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL003M", ocaid: "IA003", isbn10: "222222222X", isbn13: "9782222222224"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL003M", revision: 6, lastModified: testLastModified, ocaid: "IA003", isbn10: "222222222X", isbn13: "9782222222224", isbns: []string{"9782222222224"}, isbnStatus: IsbnBadChecksum}, expErr: nil,
 		},
 		{
 			name: "BadISBN13", input: `/type/edition	/books/OL004M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL004M", "isbn_13": ["1234567890123"], "ocaid": "IA004"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL004M", ocaid: "IA004", isbn10: "", isbn13: "1234567890123"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL004M", revision: 6, lastModified: testLastModified, ocaid: "IA004", isbn10: "", isbn13: "1234567890123", isbns: []string{"1234567890123"}, isbnStatus: IsbnBadChecksum}, expErr: nil,
 		},
 		{
 			name: "EmptyOCAID", input: `/type/edition	/books/OL005M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL005M", "isbn_13": ["1234567890123"], "ocaid": ""}`,
-			expEdition: &OpenLibraryEdition{olid: "OL005M", ocaid: "", isbn10: "", isbn13: "1234567890123"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL005M", revision: 6, lastModified: testLastModified, ocaid: "", isbn10: "", isbn13: "1234567890123", isbns: []string{"1234567890123"}, isbnStatus: IsbnBadChecksum}, expErr: nil,
 		},
 		{
 			name: "NoOCAID", input: `/type/edition	/books/OL006M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL006M", "isbn_13": ["1234567890123"]}`,
-			expEdition: &OpenLibraryEdition{olid: "OL006M", ocaid: "", isbn10: "", isbn13: "1234567890123"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL006M", revision: 6, lastModified: testLastModified, ocaid: "", isbn10: "", isbn13: "1234567890123", isbns: []string{"1234567890123"}, isbnStatus: IsbnBadChecksum}, expErr: nil,
 		},
 		{
 			name: "NoISBN", input: `/type/edition	/books/OL007M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL007M", "ocaid": "IA007"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL007M", ocaid: "IA007", isbn10: "", isbn13: ""}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL007M", revision: 6, lastModified: testLastModified, ocaid: "IA007", isbn10: "", isbn13: ""}, expErr: nil,
 		},
 		{
 			name: "TooManyColumns", input: `ExtraCol	/type/edition	/books/OL008M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL008M", "isbn_13": ["9788955565683"], "ocaid": "IA008"}`,
@@ -63,34 +68,83 @@ func TestParseOLLine(t *testing.T) {
 		},
 		{
 			name: "TwoISBNsofSameType", input: `/type/edition	/books/OL010M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL010M", "isbn_13": ["1234567890123", "9788955565683"], "ocaid": "IA010"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL010M", ocaid: "IA010", isbn10: "", isbn13: "1234567890123"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL010M", revision: 6, lastModified: testLastModified, ocaid: "IA010", isbn10: "", isbn13: "1234567890123", isbns: []string{"1234567890123", "9788955565683"}, isbnStatus: IsbnBadChecksum}, expErr: nil,
 		},
 		// Use ISBN 13 when it exists, and don't calculate the ISBN 13 based off the ISBN 10 -- even when the ISBN 10 would generate a different ISBN 13.
 		{
 			name: "IncompatibleISBN13andISBN10", input: `/type/edition	/books/OL011M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL011M", "isbn_10": ["0135043948"] "isbn_13": ["9788955565683"], "ocaid": "IA011"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL011M", ocaid: "IA011", isbn10: "0135043948", isbn13: "9788955565683"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL011M", revision: 6, lastModified: testLastModified, ocaid: "IA011", isbn10: "0135043948", isbn13: "9788955565683", isbns: []string{"9788955565683", "9780135043943"}, isbnStatus: IsbnValid}, expErr: nil,
+		},
+		{
+			name: "Author", input: `/type/author	/authors/OL011A	6	2020-12-22T19:20:44.396666	{"key": "/authors/OL011A", "name": "Charlotte Brontë"}`,
+			expEdition: &OpenLibraryAuthor{olid: "OL011A", name: "Charlotte Brontë"}, expErr: nil,
+		},
+		{
+			name: "Work", input: `/type/work	/works/OL011W	6	2020-12-22T19:20:44.396666	{"key": "/works/OL011W", "title": "Jane Eyre", "authors": [{"author": {"key": "/authors/OL011A"}, "type": {"key": "/type/author_role"}}, {"author": "/authors/OL012A"}]}`,
+			expEdition: &OpenLibraryWork{olid: "OL011W", title: "Jane Eyre", authors: []string{"OL011A", "OL012A"}}, expErr: nil,
+		},
+		{
+			name: "EditionWorks", input: `/type/edition	/books/OL018M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL018M", "works": [{"key": "/works/OL011W"}], "ocaid": "IA018"}`,
+			expEdition: &OpenLibraryEdition{olid: "OL018M", revision: 6, lastModified: testLastModified, ocaid: "IA018", works: []string{"OL011W"}}, expErr: nil,
+		},
+		{
+			name: "Redirect", input: `/type/redirect	/books/OL019M	3	2020-12-22T19:20:44.396666	{"key": "/books/OL019M", "location": "/books/OL001M", "type": {"key": "/type/redirect"}}`,
+			expEdition: &OpenLibraryRedirect{olid: "OL019M", location: "OL001M"}, expErr: nil,
+		},
+		{
+			name: "Deletion", input: `/type/delete	/books/OL020M	4	2020-12-22T19:20:44.396666	{"key": "/books/OL020M", "type": {"key": "/type/delete"}}`,
+			expEdition: &OpenLibraryDeletion{olid: "OL020M"}, expErr: nil,
+		},
+		{
+			name: "OptionalFields", input: `/type/edition	/books/OL021M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL021M", "title": "Jane Eyre", "subtitle": "An Autobiography", "publishers": ["Penguin Books"], "publish_date": "2006", "number_of_pages": 532, "languages": [{"key": "/languages/eng"}], "lccn": ["2001012345"], "oclc_numbers": ["71126926"], "source_records": ["marc:marc_records/part01.dat:123:456", "ia:janeeyre0000bron"], "identifiers": {"goodreads": ["10210"], "librarything": ["2385"]}}`,
+			expEdition: &OpenLibraryEdition{
+				olid: "OL021M", revision: 6, lastModified: testLastModified, title: "Jane Eyre", subtitle: "An Autobiography", publishers: []string{"Penguin Books"}, publishDate: "2006",
+				numberOfPages: 532, languages: []string{"eng"}, lccns: []string{"2001012345"}, oclcNumbers: []string{"71126926"},
+				sourceRecords: []string{"marc:marc_records/part01.dat:123:456", "ia:janeeyre0000bron"},
+				identifiers:   map[string][]string{"goodreads": {"10210"}, "librarything": {"2385"}},
+			}, expErr: nil,
+		},
+		{
+			name: "NumberOfPagesAsString", input: `/type/edition	/books/OL022M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL022M", "number_of_pages": "212"}`,
+			expEdition: &OpenLibraryEdition{olid: "OL022M", revision: 6, lastModified: testLastModified, numberOfPages: 212}, expErr: nil,
+		},
+		{
+			name: "BadLastModified", input: `/type/edition	/books/OL023M	6	yesterday	{"key": "/books/OL023M"}`,
+			expEdition: nil, expErr: ErrorBadRevision,
+		},
+		{
+			name: "SkipUnsupportedTypes", input: `/type/page	/about	6	2020-12-22T19:20:44.396666	{"key": "/about"}`,
+			expEdition: nil, expErr: ErrorUnsupportedType,
+		},
+		{
+			name: "ISBN10WithNon9CharIsWrongLength", input: `/type/edition	/books/OL012M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL012M", "isbn_10": ["123"], "ocaid": "IA012"}`,
+			expEdition: &OpenLibraryEdition{olid: "OL012M", revision: 6, lastModified: testLastModified, ocaid: "IA012", isbn10: "123", isbn13: "", isbnStatus: IsbnWrongLength}, expErr: nil,
 		},
 		{
-			name: "SkipNonEditions", input: `/type/author	/books/OL001A	6	2020-12-22T19:20:44.396666	{"key": "/authors/OL011A"}`,
-			expEdition: nil, expErr: ErrorNotEdition,
+			name: "HyphenatedAndLabelledISBNs", input: `/type/edition	/books/OL015M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL015M", "isbn_10": ["ISBN: 0-14-143951-3 (pbk.)"], "ocaid": "IA015"}`,
+			expEdition: &OpenLibraryEdition{olid: "OL015M", revision: 6, lastModified: testLastModified, ocaid: "IA015", isbn10: "0141439513", isbn13: "9780141439518", isbns: []string{"9780141439518"}, isbnStatus: IsbnValid}, expErr: nil,
 		},
 		{
-			name: "ISBN10WithNon9CharBecomes0000000000", input: `/type/edition	/books/OL012M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL012M", "isbn_10": ["123"], "ocaid": "IA012"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL012M", ocaid: "IA012", isbn10: "0000000000", isbn13: "9780000000002"}, expErr: nil,
+			name: "GarbageISBNSkippedForUsableOne", input: `/type/edition	/books/OL016M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL016M", "isbn_13": ["97814/4395X"], "isbn_10": ["0141439513"], "ocaid": "IA016"}`,
+			expEdition: &OpenLibraryEdition{olid: "OL016M", revision: 6, lastModified: testLastModified, ocaid: "IA016", isbn10: "0141439513", isbn13: "9780141439518", isbns: []string{"9780141439518"}, isbnStatus: IsbnValid}, expErr: nil,
+		},
+		{
+			name: "OnlyGarbageISBN", input: `/type/edition	/books/OL017M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL017M", "isbn_13": ["not an isbn"], "ocaid": "IA017"}`,
+			expEdition: &OpenLibraryEdition{olid: "OL017M", revision: 6, lastModified: testLastModified, ocaid: "IA017", isbnStatus: IsbnGarbage}, expErr: nil,
 		},
 		{
 			name: "ISBN10WithNoValue", input: `/type/edition	/books/OL013M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL013M", "isbn_10": [], "ocaid": "IA013"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL013M", ocaid: "IA013"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL013M", revision: 6, lastModified: testLastModified, ocaid: "IA013"}, expErr: nil,
 		},
 		{
 			name: "ISBN13WithNoValue", input: `/type/edition	/books/OL014M	6	2020-12-22T19:20:44.396666	{"key": "/books/OL014M", "isbn_13": [], "ocaid": "IA014"}`,
-			expEdition: &OpenLibraryEdition{olid: "OL014M", ocaid: "IA014"}, expErr: nil,
+			expEdition: &OpenLibraryEdition{olid: "OL014M", revision: 6, lastModified: testLastModified, ocaid: "IA014"}, expErr: nil,
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			edition, err := parseOLLine([]byte(tc.input))
+			edition, err := parseOLLine([]byte(tc.input), allEditionFields())
 			if tc.expErr != nil {
 				if err == nil {
 					t.Fatalf("expected error, but found no error")
@@ -108,6 +162,31 @@ func TestParseOLLine(t *testing.T) {
 	}
 }
 
+func TestParseModifiedAfter(t *testing.T) {
+	tests := []struct {
+		input  string
+		exp    time.Time
+		expErr bool
+	}{
+		{input: "", exp: time.Time{}},
+		{input: "2023-01-31", exp: time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{input: "2020-12-22T19:20:44.396666", exp: testLastModified},
+		{input: "2020-12-22T19:20:44", exp: time.Date(2020, 12, 22, 19, 20, 44, 0, time.UTC)},
+		{input: "last week", expErr: true},
+	}
+
+	for _, tc := range tests {
+		res, err := parseModifiedAfter(tc.input)
+		if (err != nil) != tc.expErr {
+			t.Fatalf("%q: expected an error: %v, but got %v", tc.input, tc.expErr, err)
+		}
+
+		if !res.Equal(tc.exp) {
+			t.Fatalf("%q: expected %v, but got %v", tc.input, tc.exp, res)
+		}
+	}
+}
+
 func TestGetOlidFromKey(t *testing.T) {
 	key := "/books/OL123M"
 	exp := "OL123M"
@@ -117,33 +196,49 @@ func TestGetOlidFromKey(t *testing.T) {
 	}
 }
 
+// editionIdentifiers returns a copy of edition with only the ocaid and ISBN
+// fields, which are what TestGetEditions checks; the other fields are covered
+// by TestParseOLLine.
+func editionIdentifiers(edition *OpenLibraryEdition) *OpenLibraryEdition {
+	return &OpenLibraryEdition{
+		olid:       edition.olid,
+		ocaid:      edition.ocaid,
+		isbn10:     edition.isbn10,
+		isbn13:     edition.isbn13,
+		isbns:      edition.isbns,
+		isbnStatus: edition.isbnStatus,
+	}
+}
+
 func TestGetEditions(t *testing.T) {
 	var resEditions []*OpenLibraryEdition
 	chunkSize := int64(1000)
-	editionsCh := make(chan *OpenLibraryEdition)
+	recordsCh := make(chan Record)
 	doneCh := make(chan struct{})
 	errCh := make(chan error)
 	out := os.Stdout
 	inFile := "./testdata/chunkTestData.txt"
 	expEditions := []*OpenLibraryEdition{
-		{olid: "OL001M", ocaid: "IA001", isbn10: "", isbn13: "9788955565683"},
-		{olid: "OL002M", ocaid: "IA002", isbn10: "0135043948", isbn13: "9780135043943"},
-		{olid: "OL10737124M", ocaid: "", isbn10: "0373086970", isbn13: "9780373086979"},
-		{olid: "OL10737484M", ocaid: "onemanslove00lisa", isbn10: "0373093586", isbn13: "9780373093588"},
-		{olid: "OL10737723M", ocaid: "", isbn10: "0373096879", isbn13: "9780373096879"},
-		{olid: "OL10738135M", ocaid: "temporarymarriag00thor", isbn10: "037310491X", isbn13: "9780373104918"},
-		{olid: "OL16775850M", ocaid: "seals0000bekk", isbn10: "", isbn13: "9781590368930"},
+		{olid: "OL001M", ocaid: "IA001", isbn10: "", isbn13: "9788955565683", isbns: []string{"9788955565683"}, isbnStatus: IsbnValid},
+		{olid: "OL002M", ocaid: "IA002", isbn10: "0135043948", isbn13: "9780135043943", isbns: []string{"9780135043943"}, isbnStatus: IsbnValid},
+		{olid: "OL10737124M", ocaid: "", isbn10: "0373086970", isbn13: "9780373086979", isbns: []string{"9780373086979"}, isbnStatus: IsbnValid},
+		{olid: "OL10737484M", ocaid: "onemanslove00lisa", isbn10: "0373093586", isbn13: "9780373093588", isbns: []string{"9780373093588"}, isbnStatus: IsbnValid},
+		{olid: "OL10737723M", ocaid: "", isbn10: "0373096879", isbn13: "9780373096879", isbns: []string{"9780373096879"}, isbnStatus: IsbnValid},
+		{olid: "OL10738135M", ocaid: "temporarymarriag00thor", isbn10: "037310491X", isbn13: "9780373104918", isbns: []string{"9780373104918"}, isbnStatus: IsbnValid},
+		{olid: "OL16775850M", ocaid: "seals0000bekk", isbn10: "", isbn13: "9781590368930", isbns: []string{"9781590368930"}, isbnStatus: IsbnValid},
 	}
 
 	// Read in editions
 	go func() {
-		for edition := range editionsCh {
-			resEditions = append(resEditions, edition)
+		for record := range recordsCh {
+			if edition, ok := record.(*OpenLibraryEdition); ok {
+				resEditions = append(resEditions, editionIdentifiers(edition))
+			}
 		}
 		defer close(doneCh)
 	}()
 
-	if err := getEditions(inFile, out, editionsCh, doneCh, errCh, chunkSize); err != nil {
+	if err := getRecords(context.Background(), inFile, NewOpenLibraryParser(allEditionFields(), time.Time{}), out, recordsCh, doneCh, errCh, chunkSize); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		t.Fatal(err)
 	}
@@ -208,9 +303,132 @@ func TestGetEditions(t *testing.T) {
 // 	}
 // }
 
+// TestAddEditionToDBBatchIsbns verifies every ISBN of an edition is written
+// to edition_isbn, not just the one stored in ol.
+func TestAddEditionToDBBatchIsbns(t *testing.T) {
+	editionsCh := make(chan Record)
+	editions := []*OpenLibraryEdition{
+		{olid: "OL001M", ocaid: "IA001", isbn10: "0135043948", isbn13: "9788955565683", isbns: []string{"9788955565683", "9780135043943"}, isbnStatus: IsbnValid},
+		{olid: "OL002M", ocaid: "IA002"},
+		{olid: "OL003M", ocaid: "", isbn13: "9781590368930", isbns: []string{"9781590368930"}, isbnStatus: IsbnValid},
+	}
+
+	go func() {
+		defer close(editionsCh)
+		for _, edition := range editions {
+			editionsCh <- edition
+		}
+	}()
+
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run, finish := testRun(t, db, "ol")
+	writer, err := newOLWriter(db, run.id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = addRecordsToDBBatch(context.Background(), editionsCh, writer); err != nil {
+		t.Fatal(err)
+	}
+	finish()
+
+	rows, err := db.Query("SELECT edition_id, isbn_13 FROM edition_isbn ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var resIsbns [][2]string
+	for rows.Next() {
+		var olid, isbn string
+		if err := rows.Scan(&olid, &isbn); err != nil {
+			t.Fatal(err)
+		}
+		resIsbns = append(resIsbns, [2]string{olid, isbn})
+	}
+
+	expIsbns := [][2]string{
+		{"OL001M", "9788955565683"},
+		{"OL001M", "9780135043943"},
+		{"OL003M", "9781590368930"},
+	}
+	if !reflect.DeepEqual(expIsbns, resIsbns) {
+		t.Fatalf("expected %v, but got %v", expIsbns, resIsbns)
+	}
+}
+
+// TestAddWorksAndAuthorsToDBBatch verifies works, authors, the edition to
+// work links and the optional edition fields end up in their own tables.
+func TestAddWorksAndAuthorsToDBBatch(t *testing.T) {
+	recordsCh := make(chan Record)
+	records := []Record{
+		&OpenLibraryEdition{
+			olid: "OL001M", works: []string{"OL001W"}, revision: 6, lastModified: testLastModified,
+			title: "Jane Eyre", publishers: []string{"Penguin Books", "Vintage"},
+			numberOfPages: 532, languages: []string{"eng"}, lccns: []string{"2001012345"}, oclcNumbers: []string{"71126926"},
+			identifiers: map[string][]string{"goodreads": {"10210"}},
+		},
+		&OpenLibraryWork{olid: "OL001W", title: "Jane Eyre", authors: []string{"OL001A", "OL002A"}},
+		&OpenLibraryAuthor{olid: "OL001A", name: "Charlotte Brontë"},
+	}
+
+	go func() {
+		defer close(recordsCh)
+		for _, record := range records {
+			recordsCh <- record
+		}
+	}()
+
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run, finish := testRun(t, db, "ol")
+	writer, err := newOLWriter(db, run.id, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = addRecordsToDBBatch(context.Background(), recordsCh, writer); err != nil {
+		t.Fatal(err)
+	}
+	finish()
+
+	tests := []struct {
+		query string
+		exp   string
+	}{
+		{query: "SELECT work_id FROM edition_work WHERE edition_id = 'OL001M'", exp: "OL001W"},
+		{query: "SELECT title FROM work WHERE work_id = 'OL001W'", exp: "Jane Eyre"},
+		{query: "SELECT group_concat(author_id) FROM work_author WHERE work_id = 'OL001W'", exp: "OL001A,OL002A"},
+		{query: "SELECT name FROM author WHERE author_id = 'OL001A'", exp: "Charlotte Brontë"},
+		{query: "SELECT title || '|' || publishers || '|' || number_of_pages || '|' || languages FROM ol WHERE edition_id = 'OL001M'", exp: "Jane Eyre|Penguin Books;Vintage|532|eng"},
+		{query: "SELECT group_concat(name || ':' || value) FROM edition_identifier WHERE edition_id = 'OL001M'", exp: "lccn:2001012345,oclc:71126926,goodreads:10210"},
+		{query: "SELECT revision || '|' || last_modified FROM ol WHERE edition_id = 'OL001M'", exp: "6|2020-12-22T19:20:44.396666"},
+		{query: "SELECT count(*) FROM ol WHERE last_modified > '2020-12-01'", exp: "1"},
+	}
+
+	for _, tc := range tests {
+		var res string
+		if err := db.QueryRow(tc.query).Scan(&res); err != nil {
+			t.Fatal(err)
+		}
+
+		if res != tc.exp {
+			t.Fatalf("%s: expected %s, but got %s", tc.query, tc.exp, res)
+		}
+	}
+}
+
 func TestAddEditionToDBBatch(t *testing.T) {
-	editionsCh := make(chan *OpenLibraryEdition)
-	doneCh := make(chan struct{})
+	editionsCh := make(chan Record)
 	// Make some editions to send to the batcher.
 	type expDBItem struct {
 		olid   string
@@ -247,9 +465,16 @@ func TestAddEditionToDBBatch(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err = addEditionToDBBatch(editionsCh, doneCh, db, 5); err != nil {
+	run, finish := testRun(t, db, "ol")
+	writer, err := newOLWriter(db, run.id, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = addRecordsToDBBatch(context.Background(), editionsCh, writer); err != nil {
 		t.Fatal(err)
 	}
+	finish()
 
 	// Ensure the DB row count is the same as expected.
 	resCount, err := db.Query("SELECT COUNT(*) FROM ol")
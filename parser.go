@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Record is anything a Parser produces from a line of a dump, such as an
+// *OpenLibraryEdition or an *IAItem.
+type Record interface{}
+
+// Parser turns a single line from a dump into a Record. Lines that aren't
+// wanted, such as unsupported record types, return ErrorSkipLine.
+type Parser interface {
+	Parse(line []byte) (Record, error)
+}
+
+// recordSplitter is implemented by Parsers for dumps whose records aren't one
+// per line, such as binary MARC. Split is a bufio.SplitFunc that returns one
+// record at a time, which is then handed to Parse. These dumps are always
+// streamed, since getChunks splits files on newlines.
+type recordSplitter interface {
+	Split(data []byte, atEOF bool) (advance int, token []byte, err error)
+}
+
+// recordWriter inserts the Records produced by a Parser into the DB. Records
+// are batched, so close must be called to insert the final batch.
+type recordWriter interface {
+	add(record Record) error
+	close() error
+}
+
+// recordAborter is implemented by recordWriters that can undo a load that
+// fails part way through, such as by rolling back its transaction. abort is
+// called instead of close.
+type recordAborter interface {
+	abort() error
+}
+
+// processLine parses a single line with parser and sends the resulting record
+// to recordsCh. Skipped lines are dropped, and other parse errors go to errCh
+// without stopping the load. It only fails if ctx is cancelled.
+func processLine(ctx context.Context, parser Parser, line []byte, recordsCh chan<- Record, errCh chan<- error) error {
+	record, err := parser.Parse(line)
+	if err != nil {
+		if errors.Is(err, ErrorSkipLine) {
+			return nil
+		}
+
+		select {
+		case errCh <- err:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	select {
+	case recordsCh <- record:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addRecordsToDBBatch reads records from recordCh and adds them to the DB with
+// writer, until recordCh is closed. If writing fails or ctx is cancelled first,
+// the writer is aborted rather than closed.
+func addRecordsToDBBatch(ctx context.Context, recordCh <-chan Record, writer recordWriter) (err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+		if a, ok := writer.(recordAborter); ok {
+			if abortErr := a.abort(); abortErr != nil {
+				err = fmt.Errorf("%w (aborting: %v)", err, abortErr)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case record, ok := <-recordCh:
+			if !ok {
+				// With recordCh closed, it's time to handle the final,
+				// partially filled batches.
+				return writer.close()
+			}
+
+			if err := writer.add(record); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestProcessLine verifies records are sent on, skipped lines are dropped, and
+// other errors are reported, for both the OL and IA parsers.
+func TestProcessLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		parser    Parser
+		line      string
+		expRecord bool
+		expErr    error
+	}{
+		{name: "OLEdition", parser: NewOpenLibraryParser(nil, time.Time{}), line: "/type/edition\t/books/OL1M\t1\t2020-12-22T19:20:44.396666\t{\"key\": \"/books/OL1M\"}", expRecord: true},
+		{name: "OLSkipped", parser: NewOpenLibraryParser(nil, time.Time{}), line: "/type/page\t/about\t1\t2020-12-22T19:20:44.396666\t{\"key\": \"/about\"}"},
+		{name: "OLError", parser: NewOpenLibraryParser(nil, time.Time{}), line: "/type/edition\t/books/OL1M", expErr: ErrorWrongColCount},
+		{name: "OLModifiedAfter", parser: NewOpenLibraryParser(nil, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)), line: "/type/edition\t/books/OL1M\t1\t2020-12-22T19:20:44.396666\t{\"key\": \"/books/OL1M\"}", expRecord: true},
+		{name: "OLNotModifiedAfter", parser: NewOpenLibraryParser(nil, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)), line: "/type/edition\t/books/OL1M\t1\t2020-12-22T19:20:44.396666\t{\"key\": \"/books/OL1M\"}"},
+		{name: "OLWorkNotFiltered", parser: NewOpenLibraryParser(nil, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)), line: "/type/work\t/works/OL1W\t1\t2020-12-22T19:20:44.396666\t{\"key\": \"/works/OL1W\"}", expRecord: true},
+		{name: "IAItem", parser: &IAParser{}, line: `{"identifier": "IA1"}`, expRecord: true},
+		{name: "IASkipped", parser: &IAParser{}, line: ""},
+		{name: "IAError", parser: &IAParser{}, line: `{"isbn": "0141439513"}`, expErr: ErrorNoIdentifier},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Buffered so processLine doesn't block.
+			recordsCh := make(chan Record, 1)
+			errCh := make(chan error, 1)
+
+			if err := processLine(context.Background(), tc.parser, []byte(tc.line), recordsCh, errCh); err != nil {
+				t.Fatal(err)
+			}
+			close(recordsCh)
+			close(errCh)
+
+			if _, ok := <-recordsCh; ok != tc.expRecord {
+				t.Fatalf("expected a record: %v, but got one: %v", tc.expRecord, ok)
+			}
+
+			if err := <-errCh; !errors.Is(err, tc.expErr) {
+				t.Fatalf("expected %v, but got %v", tc.expErr, err)
+			}
+		})
+	}
+}
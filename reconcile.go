@@ -0,0 +1,387 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// MatchReason records why a LinkCandidate was suggested.
+type MatchReason string
+
+const (
+	// ReasonUniqueIsbn: the IA item has no openlibrary_edition, and one of its
+	// ISBNs belongs to exactly one OL edition, which has no ocaid.
+	ReasonUniqueIsbn MatchReason = "unique_isbn"
+
+	// ReasonUniqueLccn and ReasonUniqueOclc: as ReasonUniqueIsbn, but with
+	// the normalized LCCN or OCLC number. Pairs already matched on ISBN
+	// aren't repeated.
+	ReasonUniqueLccn MatchReason = "unique_lccn"
+	ReasonUniqueOclc MatchReason = "unique_oclc"
+
+	// ReasonTitleAuthorYear: the IA item has no openlibrary_edition and no
+	// ISBN, and its normalized title, year and authors match an OL edition
+	// with no ocaid. This is not an ISBN match.
+	ReasonTitleAuthorYear MatchReason = "title_author_year"
+)
+
+// reasonSignals are the signals each reason's candidates were found by. They
+// agree by construction, so they don't count towards the candidates' scores.
+var reasonSignals = map[MatchReason][]Signal{
+	ReasonTitleAuthorYear: {SignalTitle, SignalYear},
+}
+
+// LinkCandidate is a suggested link between an OL edition and an IA item.
+type LinkCandidate struct {
+	olid   string
+	ocaid  string // The IA identifier to set as the edition's ocaid.
+	isbn13 string // The ISBN the match was made on, if it was.
+	lccn   string // The normalized LCCN the match was made on, if it was.
+	oclc   string // The normalized OCLC number the match was made on, if it was.
+	reason MatchReason
+	score  Score
+
+	// The identifiers, of isbn_13, lccn and oclc, that both sides have but
+	// with no value in common. These need a closer look.
+	disagreements []string
+}
+
+// candidateColumns are the columns every link candidate query returns after
+// the OLID, ocaid and matched identifier: the metadata of both for scoring,
+// which is followed by the authors and disagreementColumns.
+const candidateColumns = `
+    coalesce(ol.title, ''), coalesce(ol.subtitle, ''), coalesce(ol.publish_date, ''),
+    coalesce(ol.publishers, ''), coalesce(ol.number_of_pages, 0), coalesce(ol.languages, ''),
+    coalesce(ia.title, ''), coalesce(ia.publish_date, ''), coalesce(ia.publishers, ''),
+    coalesce(ia.image_count, 0), coalesce(ia.languages, '')`
+
+// disagreementColumns are whether the edition and the IA item both have ISBNs,
+// LCCNs and OCLC numbers, in that order, but none in common.
+const disagreementColumns = `
+    EXISTS (SELECT 1 FROM edition_isbn x WHERE x.edition_id = ol.edition_id)
+      AND EXISTS (SELECT 1 FROM ia_isbn y WHERE y.identifier = ia.identifier)
+      AND NOT EXISTS (
+        SELECT 1 FROM edition_isbn x JOIN ia_isbn y ON y.isbn_13 = x.isbn_13
+        WHERE x.edition_id = ol.edition_id AND y.identifier = ia.identifier),
+    EXISTS (SELECT 1 FROM edition_identifier x WHERE x.edition_id = ol.edition_id AND x.name = 'lccn')
+      AND EXISTS (SELECT 1 FROM ia_identifier y WHERE y.identifier = ia.identifier AND y.name = 'lccn')
+      AND NOT EXISTS (
+        SELECT 1 FROM edition_identifier x JOIN ia_identifier y ON y.name = x.name AND y.value = x.value
+        WHERE x.edition_id = ol.edition_id AND y.identifier = ia.identifier AND x.name = 'lccn'),
+    EXISTS (SELECT 1 FROM edition_identifier x WHERE x.edition_id = ol.edition_id AND x.name = 'oclc')
+      AND EXISTS (SELECT 1 FROM ia_identifier y WHERE y.identifier = ia.identifier AND y.name = 'oclc')
+      AND NOT EXISTS (
+        SELECT 1 FROM edition_identifier x JOIN ia_identifier y ON y.name = x.name AND y.value = x.value
+        WHERE x.edition_id = ol.edition_id AND y.identifier = ia.identifier AND x.name = 'oclc')`
+
+// disagreementNames name the disagreementColumns.
+var disagreementNames = []string{"isbn_13", "lccn", "oclc"}
+
+// isbnCandidatesQuery finds IA items with no openlibrary_edition that share an
+// ISBN with exactly one OL edition, where that edition has no ocaid and, unless
+// ?1 is empty, was last modified after ?1. Every usable ISBN of an edition is
+// in edition_isbn, not just the one in ol. Authors aren't needed to trust an
+// ISBN, so they're left blank.
+const isbnCandidatesQuery = `
+  WITH isbn_edition AS (
+    SELECT isbn_13, min(edition_id) AS edition_id
+    FROM edition_isbn
+    GROUP BY isbn_13
+    HAVING count(DISTINCT edition_id) = 1
+  )
+  SELECT ie.edition_id, ia.identifier, min(ii.isbn_13),` + candidateColumns + `, '', '',` + disagreementColumns + `
+  FROM ia
+  JOIN ia_isbn ii ON ii.identifier = ia.identifier
+  JOIN isbn_edition ie ON ie.isbn_13 = ii.isbn_13
+  JOIN ol ON ol.edition_id = ie.edition_id
+  WHERE coalesce(ia.ol_edition_id, '') = ''
+    AND coalesce(ol.ocaid, '') = ''
+    AND (?1 = '' OR ol.last_modified > ?1)
+  GROUP BY ie.edition_id, ia.identifier
+  ORDER BY ie.edition_id, ia.identifier`
+
+// identifierCandidatesQuery is isbnCandidatesQuery for the edition_identifier
+// and ia_identifier values named ?2, i.e. lccn or oclc.
+const identifierCandidatesQuery = `
+  WITH identifier_edition AS (
+    SELECT value, min(edition_id) AS edition_id
+    FROM edition_identifier
+    WHERE name = ?2
+    GROUP BY value
+    HAVING count(DISTINCT edition_id) = 1
+  )
+  SELECT ie.edition_id, ia.identifier, min(ii.value),` + candidateColumns + `, '', '',` + disagreementColumns + `
+  FROM ia
+  JOIN ia_identifier ii ON ii.identifier = ia.identifier AND ii.name = ?2
+  JOIN identifier_edition ie ON ie.value = ii.value
+  JOIN ol ON ol.edition_id = ie.edition_id
+  WHERE coalesce(ia.ol_edition_id, '') = ''
+    AND coalesce(ol.ocaid, '') = ''
+    AND (?1 = '' OR ol.last_modified > ?1)
+  GROUP BY ie.edition_id, ia.identifier
+  ORDER BY ie.edition_id, ia.identifier`
+
+// titleCandidatesQuery finds IA items with no openlibrary_edition and no ISBN
+// that share a match_key, the normalized title and year, with an OL edition
+// that has no ocaid and was last modified after ?1, unless it's empty. The
+// authors of the edition's works and the item's
+// creators are returned so queryLinkCandidates can check they agree, and an
+// item with no creators can't.
+const titleCandidatesQuery = `
+  SELECT ol.edition_id, ia.identifier, '',` + candidateColumns + `,
+    coalesce((
+      SELECT group_concat(a.name, ';')
+      FROM edition_work ew
+      JOIN work_author wa ON wa.work_id = ew.work_id
+      JOIN author a ON a.author_id = wa.author_id
+      WHERE ew.edition_id = ol.edition_id
+    ), ''),
+    coalesce(ia.creators, ''),` + disagreementColumns + `
+  FROM ia
+  JOIN ol ON ol.match_key = ia.match_key
+  WHERE coalesce(ia.match_key, '') != ''
+    AND coalesce(ia.creators, '') != ''
+    AND coalesce(ia.ol_edition_id, '') = ''
+    AND coalesce(ol.ocaid, '') = ''
+    AND (?1 = '' OR ol.last_modified > ?1)
+    AND NOT EXISTS (SELECT 1 FROM ia_isbn ii WHERE ii.identifier = ia.identifier)
+  ORDER BY ol.edition_id, ia.identifier`
+
+// linkCandidateColumns are the columns of the link_candidate table, with one
+// score column per Signal.
+var linkCandidateColumns = func() []string {
+	columns := []string{"olid", "ocaid", "isbn_13", "lccn", "oclc", "reason", "disagreements", "score"}
+	for s := Signal(0); s < numSignals; s++ {
+		columns = append(columns, s.String()+"_score")
+	}
+	return columns
+}()
+
+// sqlQueryer is the part of a *sql.DB or *sql.Tx that the link candidate
+// queries need.
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// getLinkCandidates calls fn with each scored LinkCandidate from
+// queryAllLinkCandidates, only suggesting a pair for the first reason it
+// matches. Rows are streamed, so fn shouldn't hold on to the candidate.
+func getLinkCandidates(db *sql.DB, fn func(c *LinkCandidate) error) error {
+	seen := make(map[[2]string]bool)
+	return queryAllLinkCandidates(db, time.Time{}, func(c *LinkCandidate) error {
+		pair := [2]string{c.olid, c.ocaid}
+		if seen[pair] {
+			return nil
+		}
+		seen[pair] = true
+
+		return fn(c)
+	})
+}
+
+// queryAllLinkCandidates runs the ISBN queries for IA <-> OL linking, then the
+// LCCN and OCLC number queries, then the title, author and year queries for
+// items without ISBNs, and calls fn with each scored LinkCandidate. A pair
+// that matches for more than one reason is returned for each. Unless
+// modifiedAfter is zero, only editions last modified after it are matched.
+func queryAllLinkCandidates(db sqlQueryer, modifiedAfter time.Time, fn func(c *LinkCandidate) error) error {
+	after := modifiedAfterArg(modifiedAfter)
+	if err := queryLinkCandidates(db, isbnCandidatesQuery, ReasonUniqueIsbn, fn, after); err != nil {
+		return err
+	}
+
+	if err := queryLinkCandidates(db, identifierCandidatesQuery, ReasonUniqueLccn, fn, after, "lccn"); err != nil {
+		return err
+	}
+
+	if err := queryLinkCandidates(db, identifierCandidatesQuery, ReasonUniqueOclc, fn, after, "oclc"); err != nil {
+		return err
+	}
+
+	return queryLinkCandidates(db, titleCandidatesQuery, ReasonTitleAuthorYear, fn, after)
+}
+
+// queryLinkCandidates runs one of the link candidate queries with args and
+// calls fn with each candidate, tagged with reason. Title matches are only
+// kept if their authors agree.
+func queryLinkCandidates(db sqlQueryer, query string, reason MatchReason, fn func(c *LinkCandidate) error, args ...interface{}) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	c := LinkCandidate{reason: reason}
+	var m matchMetadata
+	var matched, olAuthors, iaCreators string
+	disagrees := make([]bool, len(disagreementNames))
+	for rows.Next() {
+		dest := []interface{}{
+			&c.olid, &c.ocaid, &matched,
+			&m.olTitle, &m.olSubtitle, &m.olPublishDate, &m.olPublishers, &m.olPages, &m.olLanguages,
+			&m.iaTitle, &m.iaDate, &m.iaPublishers, &m.iaImageCount, &m.iaLanguages,
+			&olAuthors, &iaCreators,
+		}
+		for i := range disagrees {
+			dest = append(dest, &disagrees[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		switch reason {
+		case ReasonUniqueIsbn:
+			c.isbn13 = matched
+		case ReasonUniqueLccn:
+			c.lccn = matched
+		case ReasonUniqueOclc:
+			c.oclc = matched
+		}
+
+		c.disagreements = nil
+		for i, name := range disagreementNames {
+			if disagrees[i] {
+				c.disagreements = append(c.disagreements, name)
+			}
+		}
+
+		if reason == ReasonTitleAuthorYear && !authorsAgree(olAuthors, iaCreators) {
+			continue
+		}
+		c.score = scoreMatch(&m).without(reasonSignals[reason]...)
+
+		if err := fn(&c); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// storeLinkCandidates replaces the contents of the link_candidate table with
+// the current link candidates and their scores, in one transaction, narrowed
+// to editions last modified after modifiedAfter unless it's zero. Each is
+// inserted as the queries return it; the unique index on (olid, ocaid) keeps
+// only the first reason a pair matched for.
+func storeLinkCandidates(db *sql.DB, modifiedAfter time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM link_candidate"); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT OR IGNORE" + strings.TrimPrefix(getInsertStmt("link_candidate", linkCandidateColumns, 1), "INSERT"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if err := queryAllLinkCandidates(tx, modifiedAfter, func(c *LinkCandidate) error {
+		values := []interface{}{
+			c.olid, c.ocaid, c.isbn13, c.lccn, c.oclc, string(c.reason), strings.Join(c.disagreements, ";"), c.score.total,
+		}
+		for _, signal := range c.score.signals {
+			values = append(values, signal)
+		}
+
+		_, err := stmt.Exec(values...)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getStoredLinkCandidates calls fn with each LinkCandidate in the
+// link_candidate table with a score of at least minScore, in OLID order.
+func getStoredLinkCandidates(db *sql.DB, minScore float64, fn func(c *LinkCandidate) error) error {
+	rows, err := db.Query(
+		"SELECT "+strings.Join(linkCandidateColumns, ", ")+" FROM link_candidate WHERE score >= ? ORDER BY olid, ocaid",
+		minScore,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var c LinkCandidate
+	var reason, disagreements string
+	for rows.Next() {
+		dest := []interface{}{&c.olid, &c.ocaid, &c.isbn13, &c.lccn, &c.oclc, &reason, &disagreements, &c.score.total}
+		for i := range c.score.signals {
+			dest = append(dest, &c.score.signals[i])
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		c.reason = MatchReason(reason)
+		c.disagreements = nil
+		if disagreements != "" {
+			c.disagreements = strings.Split(disagreements, ";")
+		}
+
+		if err := fn(&c); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// runReconcile scores the link candidates in an already loaded DB, narrowed
+// to editions last modified after modifiedAfter unless it's zero, stores them
+// in link_candidate, and writes those scoring at least minScore to w.
+func runReconcile(dbName string, minScore float64, modifiedAfter time.Time, w reportWriter) error {
+	db, err := getDB(dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := storeLinkCandidates(db, modifiedAfter); err != nil {
+		return err
+	}
+
+	if err := w.writeHeader(linkCandidateColumns); err != nil {
+		return err
+	}
+
+	return getStoredLinkCandidates(db, minScore, func(c *LinkCandidate) error {
+		return w.writeRow(linkCandidateRow(c)...)
+	})
+}
+
+// writeLinkCandidates writes the link candidates in store scoring at least
+// minScore to w, as runReconcile does, but without storing them.
+func writeLinkCandidates(store Store, minScore float64, w reportWriter) error {
+	if err := w.writeHeader(linkCandidateColumns); err != nil {
+		return err
+	}
+
+	return store.linkCandidates(func(c *LinkCandidate) error {
+		if c.score.total < minScore {
+			return nil
+		}
+		return w.writeRow(linkCandidateRow(c)...)
+	})
+}
+
+// linkCandidateRow returns a candidate's values for a report, in
+// linkCandidateColumns order.
+func linkCandidateRow(c *LinkCandidate) []interface{} {
+	values := []interface{}{
+		c.olid, c.ocaid, c.isbn13, c.lccn, c.oclc, string(c.reason), c.disagreements, scoreValue(validSignal(c.score.total)),
+	}
+	for _, signal := range c.score.signals {
+		values = append(values, scoreValue(signal))
+	}
+
+	return values
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestRecords adds records to db with the recordWriter from newWriter, in
+// a complete run of their kind.
+func loadTestRecords(t *testing.T, db *sql.DB, newWriter func(db sqlExecer, runID int64, batchSize int) (recordWriter, error), records ...Record) {
+	t.Helper()
+
+	recordsCh := make(chan Record)
+	go func() {
+		defer close(recordsCh)
+		for _, record := range records {
+			recordsCh <- record
+		}
+	}()
+
+	// A batch size of 2 ensures "underflow" batches are handled.
+	run, finish := testRun(t, db, recordKind(records[0]))
+	writer, err := newWriter(db, run.id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addRecordsToDBBatch(context.Background(), recordsCh, writer); err != nil {
+		t.Fatal(err)
+	}
+	finish()
+}
+
+// testRun starts a run of kind in db, for a test that writes records itself,
+// and returns it with a func that completes it.
+func testRun(t *testing.T, db *sql.DB, kind string) (*loadRun, func()) {
+	t.Helper()
+
+	run := newLoadRun(kind, "test")
+	if err := startRun(db, run); err != nil {
+		t.Fatal(err)
+	}
+
+	return run, func() {
+		t.Helper()
+		if err := finishRun(db, run, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetLinkCandidates(t *testing.T) {
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadTestRecords(t, db, newOLWriter,
+		// A unique ISBN and no ocaid: a candidate. The LCCN matches too, but
+		// the pair is only suggested once.
+		&OpenLibraryEdition{olid: "OL001M", isbn13: "9780141439518", isbns: []string{"9780141439518"}, lccns: []string{"2002022222"}},
+		// Already has an ocaid.
+		&OpenLibraryEdition{olid: "OL002M", ocaid: "IA999", isbn13: "9780135043943", isbns: []string{"9780135043943"}},
+		// Two editions share an ISBN, so it's ambiguous.
+		&OpenLibraryEdition{olid: "OL003M", isbn13: "9788955565683", isbns: []string{"9788955565683"}},
+		&OpenLibraryEdition{olid: "OL004M", isbn13: "9788955565683", isbns: []string{"9788955565683"}},
+		// The IA item already has an openlibrary_edition.
+		&OpenLibraryEdition{olid: "OL005M", isbn13: "9781590368930", isbns: []string{"9781590368930"}},
+		// Two ISBNs shared with the same IA item give one candidate.
+		&OpenLibraryEdition{olid: "OL006M", isbn13: "9780306406157", isbns: []string{"9780306406157", "9780306406164"}},
+		// No ISBN, but the title, year and author match.
+		&OpenLibraryEdition{olid: "OL008M", works: []string{"OL008W"}, title: "The Mayor of Casterbridge", publishDate: "1886"},
+		&OpenLibraryWork{olid: "OL008W", authors: []string{"OL008A"}},
+		&OpenLibraryAuthor{olid: "OL008A", name: "Thomas Hardy"},
+		// The title and year match, but neither side has authors.
+		&OpenLibraryEdition{olid: "OL013M", title: "Emma", publishDate: "1815"},
+		// The title and year match, but only the IA item has authors.
+		&OpenLibraryEdition{olid: "OL014M", title: "Persuasion", publishDate: "1817"},
+		// A unique LCCN, but the ISBNs disagree.
+		&OpenLibraryEdition{olid: "OL011M", isbns: []string{"9781402894626"}, lccns: []string{"2001-12345"}},
+		// A unique OCLC number, but the LCCNs disagree.
+		&OpenLibraryEdition{olid: "OL012M", lccns: []string{"85000002"}, oclcNumbers: []string{"(OCoLC)ocm00012345"}},
+	)
+
+	loadTestRecords(t, db, newIAWriter,
+		&IAItem{identifier: "IA001", isbns: []string{"9780141439518"}, lccns: []string{"2002022222"}},
+		NewIAItem("IA002", []string{"9780135043943"}, "", "", nil),
+		NewIAItem("IA003", []string{"9788955565683"}, "", "", nil),
+		NewIAItem("IA005", []string{"9781590368930"}, "OL005M", "", nil),
+		NewIAItem("IA006", []string{"9780306406164", "9780306406157"}, "", "", nil),
+		NewIAItem("IA007", nil, "", "", nil),
+		&IAItem{identifier: "IA008", title: "Mayor of Casterbridge : a story", date: "1886", creators: []string{"Hardy, Thomas, 1840-1928"}},
+		// The same title and year by someone else.
+		&IAItem{identifier: "IA009", title: "The Mayor of Casterbridge", date: "1886", creators: []string{"Dickens, Charles"}},
+		// Items with an ISBN are left to the ISBN match.
+		&IAItem{identifier: "IA010", isbns: []string{"9780141439471"}, title: "The Mayor of Casterbridge", date: "1886"},
+		&IAItem{identifier: "IA011", isbns: []string{"9780141439471"}, lccns: []string{" 2001012345 "}},
+		&IAItem{identifier: "IA013", title: "Emma", date: "1815"},
+		&IAItem{identifier: "IA014", title: "Persuasion", date: "1817", creators: []string{"Austen, Jane"}},
+		&IAItem{identifier: "IA012", lccns: []string{"85000001"}, oclcNumbers: []string{"12345"}},
+	)
+
+	var resCandidates []LinkCandidate
+	if err := getLinkCandidates(db, func(c *LinkCandidate) error {
+		resCandidates = append(resCandidates, *c)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expCandidates := []LinkCandidate{
+		{olid: "OL001M", ocaid: "IA001", isbn13: "9780141439518", reason: ReasonUniqueIsbn},
+		{olid: "OL006M", ocaid: "IA006", isbn13: "9780306406157", reason: ReasonUniqueIsbn},
+		{olid: "OL011M", ocaid: "IA011", lccn: "2001012345", reason: ReasonUniqueLccn, disagreements: []string{"isbn_13"}},
+		{olid: "OL012M", ocaid: "IA012", oclc: "12345", reason: ReasonUniqueOclc, disagreements: []string{"lccn"}},
+		{
+			olid: "OL008M", ocaid: "IA008", reason: ReasonTitleAuthorYear,
+			// The title and year it was found by don't count.
+			score: scoreMatch(&matchMetadata{
+				olTitle: "The Mayor of Casterbridge", olPublishDate: "1886", iaTitle: "Mayor of Casterbridge : a story", iaDate: "1886",
+			}).without(SignalTitle, SignalYear),
+		},
+	}
+
+	if !reflect.DeepEqual(expCandidates, resCandidates) {
+		t.Fatalf("expected %v, but got %v", expCandidates, resCandidates)
+	}
+}
+
+func TestRunReconcile(t *testing.T) {
+	// A named in-memory DB with a shared cache, so runReconcile sees what the
+	// test loads.
+	const TESTDB = "file:TestRunReconcile?mode=memory&cache=shared"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	loadTestRecords(t, db, newOLWriter,
+		// The LCCN matches as well as the ISBN, but the pair is only stored once.
+		&OpenLibraryEdition{
+			olid: "OL001M", isbns: []string{"9780141439518"}, lccns: []string{"2002022222"}, title: "Jane Eyre", publishDate: "2006",
+			languages: []string{"eng"},
+		},
+		&OpenLibraryEdition{
+			olid: "OL002M", isbns: []string{"9780135043943"}, title: "Seals", publishDate: "1990",
+			lastModified: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+		},
+	)
+	loadTestRecords(t, db, newIAWriter,
+		&IAItem{
+			identifier: "IA001", isbns: []string{"9780141439518"}, lccns: []string{"2002022222"}, title: "Jane Eyre", date: "2006",
+			languages: []string{"English"},
+		},
+		&IAItem{identifier: "IA002", isbns: []string{"9780135043943"}, title: "Whales of the World", date: "2001"},
+	)
+
+	tests := []struct {
+		minScore      float64
+		modifiedAfter time.Time
+		exp           string
+	}{
+		{
+			minScore: 0,
+			exp: "olid\tocaid\tisbn_13\tlccn\toclc\treason\tdisagreements\tscore\ttitle_score\tyear_score\tpublisher_score\tpages_score\tlanguage_score\n" +
+				"OL001M\tIA001\t9780141439518\t\t\tunique_isbn\t\t0.7\t1\t1\t\t\t1\n" +
+				"OL002M\tIA002\t9780135043943\t\t\tunique_isbn\t\t0\t0\t0\t\t\t\n",
+		},
+		{
+			minScore: 0.6,
+			exp: "olid\tocaid\tisbn_13\tlccn\toclc\treason\tdisagreements\tscore\ttitle_score\tyear_score\tpublisher_score\tpages_score\tlanguage_score\n" +
+				"OL001M\tIA001\t9780141439518\t\t\tunique_isbn\t\t0.7\t1\t1\t\t\t1\n",
+		},
+		{
+			// Only editions modified since.
+			minScore: 0, modifiedAfter: time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC),
+			exp: "olid\tocaid\tisbn_13\tlccn\toclc\treason\tdisagreements\tscore\ttitle_score\tyear_score\tpublisher_score\tpages_score\tlanguage_score\n" +
+				"OL002M\tIA002\t9780135043943\t\t\tunique_isbn\t\t0\t0\t0\t\t\t\n",
+		},
+	}
+
+	for _, tc := range tests {
+		// Running it twice shows link_candidate is replaced rather than added to.
+		var out strings.Builder
+		if err := runReport("reconcile", TESTDB, "tsv", tc.minScore, tc.modifiedAfter, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.String() != tc.exp {
+			t.Fatalf("%v: expected %q, but got %q", tc.minScore, tc.exp, out.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// RedirectResolver follows redirect chains, as loaded from the redirect and
+// deletion tables, to the OLID a record was ultimately merged into.
+type RedirectResolver struct {
+	redirects map[string]string
+	deletions map[string]bool
+}
+
+func NewRedirectResolver(redirects map[string]string, deletions map[string]bool) *RedirectResolver {
+	return &RedirectResolver{
+		redirects: redirects,
+		deletions: deletions,
+	}
+}
+
+// getRedirectResolver loads the redirect and deletion tables into a
+// *RedirectResolver.
+func getRedirectResolver(db *sql.DB) (*RedirectResolver, error) {
+	r := NewRedirectResolver(map[string]string{}, map[string]bool{})
+
+	rows, err := db.Query("SELECT from_id, to_id FROM redirect")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var from, to string
+		if err := rows.Scan(&from, &to); err != nil {
+			return nil, err
+		}
+		r.redirects[from] = to
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT olid FROM deletion")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var olid string
+		if err := rows.Scan(&olid); err != nil {
+			return nil, err
+		}
+		r.deletions[olid] = true
+	}
+
+	return r, rows.Err()
+}
+
+// resolve follows any redirects from olid and returns the OLID at the end of
+// the chain, which is olid itself if it isn't redirected. It returns
+// ErrorOlidDeleted if the chain ends at a deleted record, and
+// ErrorRedirectLoop if the chain never ends.
+func (r *RedirectResolver) resolve(olid string) (string, error) {
+	seen := map[string]bool{}
+	current := olid
+
+	for {
+		if r.deletions[current] {
+			return "", fmt.Errorf("%v (via %v): %w", current, olid, ErrorOlidDeleted)
+		}
+
+		next, ok := r.redirects[current]
+		if !ok || next == "" {
+			return current, nil
+		}
+
+		seen[current] = true
+		if seen[next] {
+			return "", fmt.Errorf("%v: %w", olid, ErrorRedirectLoop)
+		}
+		current = next
+	}
+}
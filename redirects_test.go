@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	r := NewRedirectResolver(
+		map[string]string{
+			"OL1M": "OL2M",
+			"OL2M": "OL3M",
+			"OL4M": "OL5M",
+			"OL6M": "OL7M",
+			"OL7M": "OL6M",
+		},
+		map[string]bool{"OL5M": true, "OL8M": true},
+	)
+
+	tests := []struct {
+		name   string
+		olid   string
+		exp    string
+		expErr error
+	}{
+		{name: "NotRedirected", olid: "OL3M", exp: "OL3M"},
+		{name: "Chain", olid: "OL1M", exp: "OL3M"},
+		{name: "ChainEndsDeleted", olid: "OL4M", expErr: ErrorOlidDeleted},
+		{name: "Deleted", olid: "OL8M", expErr: ErrorOlidDeleted},
+		{name: "Loop", olid: "OL6M", expErr: ErrorRedirectLoop},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := r.resolve(tc.olid)
+			if !errors.Is(err, tc.expErr) {
+				t.Fatalf("expected %v, but got %v", tc.expErr, err)
+			}
+
+			if res != tc.exp {
+				t.Fatalf("expected %s, but got %s", tc.exp, res)
+			}
+		})
+	}
+}
+
+// TestGetRedirectResolver loads redirects and deletions through the DB.
+func TestGetRedirectResolver(t *testing.T) {
+	recordsCh := make(chan Record)
+	records := []Record{
+		&OpenLibraryRedirect{olid: "OL1M", location: "OL2M"},
+		&OpenLibraryRedirect{olid: "OL2M", location: "OL3M"},
+		&OpenLibraryDeletion{olid: "OL4M"},
+	}
+
+	go func() {
+		defer close(recordsCh)
+		for _, record := range records {
+			recordsCh <- record
+		}
+	}()
+
+	const TESTDB = ":memory:?_sync=0&_journal=WAL"
+	db, err := getDB(TESTDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run, finish := testRun(t, db, "ol")
+	writer, err := newOLWriter(db, run.id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = addRecordsToDBBatch(context.Background(), recordsCh, writer); err != nil {
+		t.Fatal(err)
+	}
+	finish()
+
+	r, err := getRedirectResolver(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err := r.resolve("OL1M"); err != nil || res != "OL3M" {
+		t.Fatalf("expected OL3M, but got %s (%v)", res, err)
+	}
+
+	if _, err := r.resolve("OL4M"); !errors.Is(err, ErrorOlidDeleted) {
+		t.Fatalf("expected %v, but got %v", ErrorOlidDeleted, err)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportWriter writes a report one row at a time, so reports of any size can
+// be streamed. Values are strings, numbers, []string or nil; each format
+// renders them its own way. close must be called to finish the output.
+type reportWriter interface {
+	writeHeader(columns []string) error
+	writeRow(values ...interface{}) error
+	close() error
+}
+
+// reportFormats are the formats newReportWriter supports.
+var reportFormats = []string{"tsv", "csv", "jsonl", "html"}
+
+// newReportWriter returns a reportWriter for format, one of reportFormats,
+// that writes to out. title is only used by formats that show one, like html.
+func newReportWriter(format, title string, out io.Writer) (reportWriter, error) {
+	switch format {
+	case "tsv":
+		return &tsvReportWriter{w: bufio.NewWriter(out)}, nil
+	case "csv":
+		return &csvReportWriter{w: csv.NewWriter(out)}, nil
+	case "jsonl":
+		return &jsonlReportWriter{w: bufio.NewWriter(out)}, nil
+	case "html":
+		return &htmlReportWriter{w: bufio.NewWriter(out), title: title}, nil
+	}
+
+	return nil, fmt.Errorf("%v (want one of %v): %w", format, strings.Join(reportFormats, ", "), ErrorUnknownFormat)
+}
+
+// formatValue renders a report value as text, for the formats without types.
+// Lists are ; separated, as in the DB, and nil is blank.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ";")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// scoreValue rounds a score to two places for a report, or returns nil if
+// it's not valid.
+func scoreValue(score sql.NullFloat64) interface{} {
+	if !score.Valid {
+		return nil
+	}
+	return math.Round(score.Float64*100) / 100
+}
+
+// tsvReportWriter writes tab separated values. Tabs and newlines within
+// values are replaced with spaces, as TSV has no quoting.
+type tsvReportWriter struct {
+	w *bufio.Writer
+}
+
+var tsvReplacer = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+
+func (t *tsvReportWriter) writeHeader(columns []string) error {
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = column
+	}
+	return t.writeRow(values...)
+}
+
+func (t *tsvReportWriter) writeRow(values ...interface{}) error {
+	for i, v := range values {
+		if i > 0 {
+			if err := t.w.WriteByte('\t'); err != nil {
+				return err
+			}
+		}
+		if _, err := t.w.WriteString(tsvReplacer.Replace(formatValue(v))); err != nil {
+			return err
+		}
+	}
+	return t.w.WriteByte('\n')
+}
+
+func (t *tsvReportWriter) close() error {
+	return t.w.Flush()
+}
+
+// csvReportWriter writes comma separated values, quoted where needed.
+type csvReportWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvReportWriter) writeHeader(columns []string) error {
+	return c.w.Write(columns)
+}
+
+func (c *csvReportWriter) writeRow(values ...interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatValue(v)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvReportWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlReportWriter writes one JSON object per row, keyed by column, with the
+// keys in column order.
+type jsonlReportWriter struct {
+	w       *bufio.Writer
+	columns []string
+	buf     bytes.Buffer
+	enc     *json.Encoder // Encodes to buf, without escaping HTML.
+}
+
+func (j *jsonlReportWriter) writeHeader(columns []string) error {
+	j.columns = columns
+	j.enc = json.NewEncoder(&j.buf)
+	j.enc.SetEscapeHTML(false)
+	return nil
+}
+
+func (j *jsonlReportWriter) writeRow(values ...interface{}) error {
+	j.buf.Reset()
+	j.buf.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			j.buf.WriteByte(',')
+		}
+
+		// Encode adds a newline after each value, which is dropped.
+		if err := j.enc.Encode(j.columns[i]); err != nil {
+			return err
+		}
+		j.buf.Truncate(j.buf.Len() - 1)
+		j.buf.WriteByte(':')
+
+		if err := j.enc.Encode(v); err != nil {
+			return err
+		}
+		j.buf.Truncate(j.buf.Len() - 1)
+	}
+	j.buf.WriteString("}\n")
+
+	_, err := j.w.Write(j.buf.Bytes())
+	return err
+}
+
+func (j *jsonlReportWriter) close() error {
+	return j.w.Flush()
+}
+
+// htmlReportWriter writes a self-contained HTML page with the report as a
+// table. Clicking a column header sorts by that column; the script is at the
+// end so the rows can be streamed before it.
+type htmlReportWriter struct {
+	w     *bufio.Writer
+	title string
+}
+
+const htmlReportHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%[1]s</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; }
+th { background: #eee; cursor: pointer; position: sticky; top: 0; }
+th.asc::after { content: " \25B2"; }
+th.desc::after { content: " \25BC"; }
+tr:nth-child(even) td { background: #f8f8f8; }
+</style>
+</head>
+<body>
+<h1>%[1]s</h1>
+<table>
+<thead><tr>`
+
+const htmlReportFoot = `</tbody>
+</table>
+<script>
+document.querySelectorAll("th").forEach(function (th, col) {
+  th.addEventListener("click", function () {
+    var asc = !th.classList.contains("asc");
+    document.querySelectorAll("th").forEach(function (h) { h.classList.remove("asc", "desc"); });
+    th.classList.add(asc ? "asc" : "desc");
+
+    var tbody = document.querySelector("tbody");
+    var rows = Array.prototype.slice.call(tbody.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[col].textContent, y = b.cells[col].textContent;
+      var nx = parseFloat(x), ny = parseFloat(y);
+      var cmp = (!isNaN(nx) && !isNaN(ny)) ? nx - ny : x.localeCompare(y);
+      return asc ? cmp : -cmp;
+    });
+    rows.forEach(function (row) { tbody.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+`
+
+func (h *htmlReportWriter) writeHeader(columns []string) error {
+	if _, err := fmt.Fprintf(h.w, htmlReportHead, html.EscapeString(h.title)); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if _, err := fmt.Fprintf(h.w, "<th>%s</th>", html.EscapeString(column)); err != nil {
+			return err
+		}
+	}
+
+	_, err := h.w.WriteString("</tr></thead>\n<tbody>\n")
+	return err
+}
+
+func (h *htmlReportWriter) writeRow(values ...interface{}) error {
+	if _, err := h.w.WriteString("<tr>"); err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		if _, err := fmt.Fprintf(h.w, "<td>%s</td>", html.EscapeString(formatValue(v))); err != nil {
+			return err
+		}
+	}
+
+	_, err := h.w.WriteString("</tr>\n")
+	return err
+}
+
+func (h *htmlReportWriter) close() error {
+	if _, err := h.w.WriteString(htmlReportFoot); err != nil {
+		return err
+	}
+	return h.w.Flush()
+}
+
+// modifiedAfterArg is the ?1 argument of the report queries that narrow to
+// editions last modified after t, as last_modified text, or "" for the zero
+// time, which keeps every edition.
+func modifiedAfterArg(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(LASTMODIFIEDLAYOUT)
+}
+
+// runReport writes the report named reportType, one of reconcile, conflicts,
+// dangling, duplicates or runs, from an already loaded DB to out in format.
+// Unless it's zero, modifiedAfter narrows reconcile, conflicts and dangling
+// to editions last modified after it.
+func runReport(reportType, dbName, format string, minScore float64, modifiedAfter time.Time, out io.Writer) error {
+	w, err := newReportWriter(format, "reconcile-go "+reportType, out)
+	if err != nil {
+		return err
+	}
+
+	switch reportType {
+	case "reconcile":
+		err = runReconcile(dbName, minScore, modifiedAfter, w)
+	case "conflicts":
+		err = runConflicts(dbName, modifiedAfter, w)
+	case "dangling":
+		err = runDangling(dbName, modifiedAfter, w)
+	case "duplicates":
+		err = runDuplicates(dbName, w)
+	case "runs":
+		err = runRuns(dbName, w)
+	default:
+		return fmt.Errorf("%v: %w", reportType, ErrorUnknownReport)
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.close()
+}
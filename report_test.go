@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReportWriters(t *testing.T) {
+	columns := []string{"olid", "ocaids", "score", "note"}
+	rows := [][]interface{}{
+		{"OL001M", []string{"IA001", "IA002"}, 0.93, "a, \"quoted\"\tnote"},
+		{"OL002M", []string(nil), nil, "<b>"},
+	}
+
+	tests := []struct {
+		format   string
+		exp      string
+		contains []string
+	}{
+		{
+			format: "tsv",
+			exp:    "olid\tocaids\tscore\tnote\nOL001M\tIA001;IA002\t0.93\ta, \"quoted\" note\nOL002M\t\t\t<b>\n",
+		},
+		{
+			format: "csv",
+			exp:    "olid,ocaids,score,note\nOL001M,IA001;IA002,0.93,\"a, \"\"quoted\"\"\tnote\"\nOL002M,,,<b>\n",
+		},
+		{
+			format: "jsonl",
+			exp: `{"olid":"OL001M","ocaids":["IA001","IA002"],"score":0.93,"note":"a, \"quoted\"\tnote"}` + "\n" +
+				`{"olid":"OL002M","ocaids":null,"score":null,"note":"<b>"}` + "\n",
+		},
+		{
+			format: "html",
+			contains: []string{
+				"<title>Test &amp; report</title>",
+				"<th>olid</th><th>ocaids</th><th>score</th><th>note</th>",
+				"<tr><td>OL001M</td><td>IA001;IA002</td><td>0.93</td><td>a, &#34;quoted&#34;\tnote</td></tr>",
+				"<tr><td>OL002M</td><td></td><td></td><td>&lt;b&gt;</td></tr>",
+				"</html>",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.format, func(t *testing.T) {
+			var out strings.Builder
+			w, err := newReportWriter(tc.format, "Test & report", &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.writeHeader(columns); err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range rows {
+				if err := w.writeRow(row...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.exp != "" && out.String() != tc.exp {
+				t.Fatalf("expected %q, but got %q", tc.exp, out.String())
+			}
+
+			for _, s := range tc.contains {
+				if !strings.Contains(out.String(), s) {
+					t.Fatalf("expected output to contain %q, but got %q", s, out.String())
+				}
+			}
+		})
+	}
+
+	if _, err := newReportWriter("xml", "", &strings.Builder{}); !errors.Is(err, ErrorUnknownFormat) {
+		t.Fatalf("expected %v, but got %v", ErrorUnknownFormat, err)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// RunStatus is where a load run got to.
+type RunStatus string
+
+const (
+	// RunLoading: the run started and hasn't ended, or the process died
+	// before it could.
+	RunLoading RunStatus = "loading"
+
+	// RunComplete: the run loaded its whole dump. The latest complete run of
+	// each kind is the snapshot the reports see.
+	RunComplete RunStatus = "complete"
+
+	// RunFailed: the run stopped on an error and was rolled back.
+	RunFailed RunStatus = "failed"
+
+	// RunPruned: the run completed, but its rows were since deleted by
+	// -keep-runs.
+	RunPruned RunStatus = "pruned"
+)
+
+// runKinds are the kinds of dump a run loads, and the tables each loads into.
+// Each table's rows are kept in <table>_all, tagged with their run_id, and
+// <table> is a view of those from the latest complete run of its kind.
+var runKinds = []struct {
+	kind   string
+	tables []string
+}{
+	{"ol", []string{
+		"ol", "edition_isbn", "edition_work", "edition_identifier", "work", "work_author", "author", "redirect", "deletion",
+	}},
+	{"ia", []string{"ia", "ia_isbn", "ia_identifier"}},
+	{"marc", []string{"marc"}},
+}
+
+// runKindTables returns the tables a run of kind loads into.
+func runKindTables(kind string) []string {
+	for _, k := range runKinds {
+		if k.kind == kind {
+			return k.tables
+		}
+	}
+
+	return nil
+}
+
+// recordKind returns the kind of run that loads record.
+func recordKind(record Record) string {
+	switch record.(type) {
+	case *IAItem:
+		return "ia"
+	case *MARCRecord:
+		return "marc"
+	}
+
+	return "ol"
+}
+
+// keepRuns is set from -keep-runs. After a load, all but the latest keepRuns
+// complete runs of its kind are pruned, unless it's 0.
+var keepRuns int
+
+// loadRun is one load of a dump, recorded in the run table.
+type loadRun struct {
+	id         int64
+	kind       string // One of runKinds.
+	dumpFile   string
+	dumpSize   int64 // Set by hashDump, for a dump read in chunks.
+	dumpSHA256 string
+	dumpDate   string // From the file name, if it has one, as 2006-01-02.
+	errors     int    // Lines that couldn't be parsed.
+
+	// Set by hashStream, for a dump read as a stream.
+	stream *dumpHash
+
+	// From -modified-after, for an OL load of only the editions modified
+	// since. It's zero for a full load.
+	modifiedAfter time.Time
+}
+
+func newLoadRun(kind, dumpFile string) *loadRun {
+	return &loadRun{kind: kind, dumpFile: dumpFile, dumpDate: parseDumpDate(dumpFile)}
+}
+
+// dumpDateRe matches the date in dump file names such as
+// ol_dump_2023-01-31.txt.gz or ia_metadata_20230131.jsonl.
+var dumpDateRe = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})`)
+
+// parseDumpDate returns the date in the name of dumpFile, or "".
+func parseDumpDate(dumpFile string) string {
+	m := dumpDateRe.FindStringSubmatch(filepath.Base(dumpFile))
+	if m == nil {
+		return ""
+	}
+
+	date, err := time.Parse("2006-01-02", m[1]+"-"+m[2]+"-"+m[3])
+	if err != nil {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
+
+// dumpHash is the size and SHA-256 of what's written to it.
+type dumpHash struct {
+	sha256 hash.Hash
+	size   int64
+}
+
+func (d *dumpHash) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.sha256.Write(p)
+}
+
+// hashStream returns a writer for the dump, as it's read as a stream, that
+// gives run its dump size and SHA-256 once the run completes. That covers
+// stdin and pipes, which can only be read once.
+func (run *loadRun) hashStream() io.Writer {
+	run.stream = &dumpHash{sha256: sha256.New()}
+	return run.stream
+}
+
+// hashDump starts a GoRoutine in g that sets run's dump size and SHA-256 by
+// reading its file alongside the parsers, for a dump that's read in chunks
+// rather than as one stream.
+func hashDump(ctx context.Context, g *errgroup.Group, run *loadRun) {
+	g.Go(func() error {
+		f, err := os.Open(run.dumpFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		buf := make([]byte, 1<<20)
+		var size int64
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			n, err := f.Read(buf)
+			h.Write(buf[:n])
+			size += int64(n)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		run.dumpSize = size
+		run.dumpSHA256 = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+}
+
+// startRun records run as loading and sets its id.
+func startRun(db *sql.DB, run *loadRun) error {
+	var modifiedAfter sql.NullString
+	if !run.modifiedAfter.IsZero() {
+		modifiedAfter = sql.NullString{String: run.modifiedAfter.Format(LASTMODIFIEDLAYOUT), Valid: true}
+	}
+
+	res, err := db.Exec(
+		"INSERT INTO run (kind, dump_file, dump_date, modified_after, started_at, status) VALUES (?, ?, ?, ?, ?, ?)",
+		run.kind, run.dumpFile, sql.NullString{String: run.dumpDate, Valid: run.dumpDate != ""}, modifiedAfter,
+		time.Now().UTC().Format(time.RFC3339), RunLoading,
+	)
+	if err != nil {
+		return err
+	}
+
+	run.id, err = res.LastInsertId()
+	return err
+}
+
+// layeredTables are the tables of an ol run that hold editions, keyed by
+// edition_id. ol is last, as the others are copied by whether it has their
+// edition.
+var layeredTables = []string{"edition_isbn", "edition_work", "edition_identifier", "ol"}
+
+// layerRun lays run, a load of only the editions modified after a date, over
+// the previous complete run of its kind. The editions that run has, but run
+// doesn't, are copied into run, unless its dump deletes or redirects them.
+// That makes run a whole snapshot, as a full load would be, so the reports
+// can use it alone, and the earlier run can be pruned. The works, authors,
+// redirects and deletions aren't filtered, so run already has them all.
+func layerRun(tx *sql.Tx, run *loadRun) error {
+	var previous sql.NullInt64
+	if err := tx.QueryRow(
+		"SELECT max(id) FROM run WHERE kind = ? AND status = ? AND id < ?", run.kind, RunComplete, run.id,
+	).Scan(&previous); err != nil {
+		return err
+	}
+	if !previous.Valid {
+		return nil
+	}
+
+	for _, table := range layeredTables {
+		columns, err := copiedColumns(tx, table+"_all")
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf(`
+  INSERT INTO %[1]s_all (%[2]s, run_id)
+    SELECT %[2]s, ?1 FROM %[1]s_all
+    WHERE run_id = ?2
+      AND edition_id NOT IN (SELECT edition_id FROM ol_all WHERE run_id = ?1)
+      AND edition_id NOT IN (SELECT olid FROM deletion_all WHERE run_id = ?1)
+      AND edition_id NOT IN (SELECT from_id FROM redirect_all WHERE run_id = ?1)`, table, strings.Join(columns, ", ")),
+			run.id, previous.Int64,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copiedColumns returns the columns of table, other than its id and run_id,
+// for copying its rows to another run.
+func copiedColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?) WHERE name NOT IN ('id', 'run_id') ORDER BY cid", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+
+	return columns, rows.Err()
+}
+
+// finishRun records how run ended: complete, with the number of rows it
+// loaded into each table, or failed with loadErr.
+func finishRun(db *sql.DB, run *loadRun, loadErr error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status, message := RunComplete, sql.NullString{}
+	if loadErr != nil {
+		status, message = RunFailed, sql.NullString{String: loadErr.Error(), Valid: true}
+	}
+
+	// A stream has only been read to its end if the run completed.
+	size, sha := run.dumpSize, run.dumpSHA256
+	if run.stream != nil && loadErr == nil {
+		size, sha = run.stream.size, hex.EncodeToString(run.stream.sha256.Sum(nil))
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE run SET status = ?, finished_at = ?, dump_size = ?, dump_sha256 = ?, errors = ?, error = ? WHERE id = ?",
+		status, time.Now().UTC().Format(time.RFC3339),
+		sql.NullInt64{Int64: size, Valid: sha != ""},
+		sql.NullString{String: sha, Valid: sha != ""},
+		run.errors, message, run.id,
+	); err != nil {
+		return err
+	}
+
+	if loadErr == nil {
+		for _, table := range runKindTables(run.kind) {
+			if _, err := tx.Exec(
+				"INSERT INTO run_rows (run_id, table_name, rows) SELECT ?1, ?2, count(*) FROM "+table+"_all WHERE run_id = ?1",
+				run.id, table,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pruneRunsQuery selects the runs of kind ?1 older than the latest complete
+// one that aren't among the ?2 latest complete runs and may still have rows.
+// Failed runs were rolled back, so have none, but interrupted ones may.
+const pruneRunsQuery = `
+  SELECT id FROM run
+  WHERE kind = ?1 AND status IN ('complete', 'loading')
+    AND id < (SELECT max(id) FROM run WHERE kind = ?1 AND status = 'complete')
+    AND id NOT IN (SELECT id FROM run WHERE kind = ?1 AND status = 'complete' ORDER BY id DESC LIMIT ?2)
+  ORDER BY id`
+
+// pruneRuns deletes the rows of kind's runs other than the keep latest
+// complete ones, and marks those runs pruned. It returns the pruned runs.
+func pruneRuns(db *sql.DB, kind string, keep int) ([]int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(pruneRunsQuery, kind, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pruned = append(pruned, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range pruned {
+		for _, table := range runKindTables(kind) {
+			if _, err := tx.Exec("DELETE FROM "+table+"_all WHERE run_id = ?", id); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := tx.Exec("UPDATE run SET status = ? WHERE id = ?", RunPruned, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return pruned, tx.Commit()
+}
+
+// runColumns are the columns of the runs report.
+var runColumns = []string{
+	"id", "kind", "dump_file", "dump_date", "modified_after", "dump_size", "dump_sha256", "started_at", "finished_at",
+	"status", "errors", "rows", "error",
+}
+
+// runsQuery selects every run for the runs report, oldest first. rows is
+// each table the run loaded and its row count, e.g. ia=10;ia_isbn=12.
+const runsQuery = `
+  SELECT r.id, r.kind, r.dump_file, coalesce(r.dump_date, ''), coalesce(r.modified_after, ''), r.dump_size,
+    coalesce(r.dump_sha256, ''),
+    r.started_at, coalesce(r.finished_at, ''), r.status, coalesce(r.errors, 0),
+    coalesce((SELECT group_concat(rr.table_name || '=' || rr.rows, ';') FROM run_rows rr WHERE rr.run_id = r.id), ''),
+    coalesce(r.error, '')
+  FROM run r
+  ORDER BY r.id`
+
+// runRuns writes every load run in an already loaded DB to w.
+func runRuns(dbName string, w reportWriter) error {
+	db, err := getDB(dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := w.writeHeader(runColumns); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(runsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, errorCount int64
+		var size sql.NullInt64
+		var kind, dumpFile, dumpDate, modifiedAfter, sha, startedAt, finishedAt, status, tableRows, message string
+		if err := rows.Scan(
+			&id, &kind, &dumpFile, &dumpDate, &modifiedAfter, &size, &sha, &startedAt, &finishedAt, &status, &errorCount,
+			&tableRows, &message,
+		); err != nil {
+			return err
+		}
+
+		var sizeValue interface{}
+		if size.Valid {
+			sizeValue = size.Int64
+		}
+
+		if err := w.writeRow(
+			id, kind, dumpFile, dumpDate, modifiedAfter, sizeValue, sha, startedAt, finishedAt, status, errorCount,
+			splitStored(tableRows, ";"), message,
+		); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestParseDumpDate(t *testing.T) {
+	tests := []struct {
+		dumpFile string
+		exp      string
+	}{
+		{"/data/ol_dump_2023-01-31.txt.gz", "2023-01-31"},
+		{"ia_metadata_20230131.jsonl", "2023-01-31"},
+		{"ol_dump_latest.txt.gz", ""},
+		{"ol_dump_2023-13-45.txt", ""},
+		{"-", ""},
+	}
+
+	for _, tc := range tests {
+		if res := parseDumpDate(tc.dumpFile); res != tc.exp {
+			t.Fatalf("%v: expected %q, but got %q", tc.dumpFile, tc.exp, res)
+		}
+	}
+}
+
+func TestRuns(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newSQLiteStore(filepath.Join(dir, "test.db") + DBOPTIONS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+
+	defer func(keep int) { keepRuns = keep }(keepRuns)
+	keepRuns = 0
+
+	// loadEditions loads a dump of the editions, with a bad line, as a run,
+	// returning its dump's SHA-256.
+	loadEditions := func(name string, olids ...string) string {
+		t.Helper()
+
+		dump := "not a dump line\n"
+		for _, olid := range olids {
+			dump += "/type/edition\t/books/" + olid + "\t1\t2020-12-22T19:20:44.396666\t{\"key\": \"/books/" + olid + "\"}\n"
+		}
+		dumpFile := filepath.Join(dir, name)
+		if err := os.WriteFile(dumpFile, []byte(dump), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := loadDump(context.Background(), store, "ol", dumpFile, NewOpenLibraryParser(nil, modifiedAfter), io.Discard); err != nil {
+			t.Fatal(err)
+		}
+
+		sum := sha256.Sum256([]byte(dump))
+		return hex.EncodeToString(sum[:])
+	}
+
+	// expect checks the editions in the latest run, and each run's status.
+	expect := func(expOlids []string, expStatuses []string) {
+		t.Helper()
+
+		var olids, statuses []string
+		rows, err := store.db.Query("SELECT edition_id FROM ol ORDER BY edition_id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var olid string
+			if err := rows.Scan(&olid); err != nil {
+				t.Fatal(err)
+			}
+			olids = append(olids, olid)
+		}
+		rows.Close()
+
+		rows, err = store.db.Query("SELECT status FROM run ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var status string
+			if err := rows.Scan(&status); err != nil {
+				t.Fatal(err)
+			}
+			statuses = append(statuses, status)
+		}
+		rows.Close()
+
+		if !reflect.DeepEqual(expOlids, olids) || !reflect.DeepEqual(expStatuses, statuses) {
+			t.Fatalf("expected editions %v and runs %v, but got %v and %v", expOlids, expStatuses, olids, statuses)
+		}
+	}
+
+	sha := loadEditions("ol_dump_2023-01-31.txt", "OL1M", "OL2M")
+	expect([]string{"OL1M", "OL2M"}, []string{"complete"})
+
+	var dumpDate, dumpSHA256, tableRows string
+	var dumpSize int64
+	var parseErrors int
+	if err := store.db.QueryRow(`
+	  SELECT dump_date, dump_size, dump_sha256, errors,
+	    (SELECT group_concat(table_name || '=' || rows) FROM run_rows WHERE run_id = run.id AND rows > 0)
+	  FROM run WHERE id = 1`).Scan(&dumpDate, &dumpSize, &dumpSHA256, &parseErrors, &tableRows); err != nil {
+		t.Fatal(err)
+	}
+	if dumpDate != "2023-01-31" || dumpSize == 0 || dumpSHA256 != sha || parseErrors != 1 || tableRows != "ol=2" {
+		t.Fatalf("unexpected run: %v %v %v %v %v", dumpDate, dumpSize, dumpSHA256, parseErrors, tableRows)
+	}
+
+	// Loading again replaces the snapshot, rather than adding to it.
+	loadEditions("ol_dump_2023-02-28.txt", "OL2M", "OL3M")
+	expect([]string{"OL2M", "OL3M"}, []string{"complete", "complete"})
+
+	// A failed run is recorded, but doesn't change the snapshot.
+	errDiskFull := errors.New("disk full")
+	if err := loadRecords(context.Background(), store, newLoadRun("ol", "ol_dump_2023-03-31.txt"), io.Discard, func(ctx context.Context, g *errgroup.Group, recordsCh chan<- Record, errCh chan<- error) error {
+		recordsCh <- &OpenLibraryEdition{olid: "OL4M"}
+		return errDiskFull
+	}); !errors.Is(err, errDiskFull) {
+		t.Fatalf("expected %v, but got %v", errDiskFull, err)
+	}
+	expect([]string{"OL2M", "OL3M"}, []string{"complete", "complete", "failed"})
+
+	// Only the latest run of each kind is kept.
+	keepRuns = 1
+	loadEditions("ol_dump_2023-04-30.txt", "OL5M")
+	expect([]string{"OL5M"}, []string{"pruned", "pruned", "failed", "complete"})
+
+	var rows int
+	if err := store.db.QueryRow("SELECT count(*) FROM ol_all").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Fatalf("expected the pruned runs' rows to be deleted, but got %d rows", rows)
+	}
+}
+
+func TestIncrementalRun(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newSQLiteStore(filepath.Join(dir, "test.db") + DBOPTIONS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+
+	load := func(name, dump string, modifiedAfter time.Time) {
+		t.Helper()
+
+		dumpFile := filepath.Join(dir, name)
+		if err := os.WriteFile(dumpFile, []byte(dump), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := loadDump(context.Background(), store, "ol", dumpFile, NewOpenLibraryParser(nil, modifiedAfter), io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	load("ol_dump_2023-01-31.txt", `/type/edition	/books/OL1M	1	2023-01-10T00:00:00	{"key": "/books/OL1M", "isbn_13": ["9780141439518"]}
+/type/edition	/books/OL2M	1	2023-01-10T00:00:00	{"key": "/books/OL2M", "isbn_13": ["9780135043943"], "ocaid": "IA002"}
+/type/edition	/books/OL3M	1	2023-01-10T00:00:00	{"key": "/books/OL3M"}
+/type/edition	/books/OL4M	1	2023-01-10T00:00:00	{"key": "/books/OL4M"}
+`, time.Time{})
+
+	// OL1M gained an ocaid, OL3M was deleted, OL4M merged into OL1M, and
+	// OL5M is new. OL2M hasn't changed, so the incremental load skips it.
+	modifiedAfter, err := parseModifiedAfter("2023-01-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	load("ol_dump_2023-02-28.txt", `/type/edition	/books/OL1M	2	2023-02-10T00:00:00	{"key": "/books/OL1M", "isbn_13": ["9780141439518"], "ocaid": "IA001"}
+/type/edition	/books/OL2M	1	2023-01-10T00:00:00	{"key": "/books/OL2M", "isbn_13": ["9780135043943"], "ocaid": "IA002"}
+/type/delete	/books/OL3M	2	2023-02-10T00:00:00	{"key": "/books/OL3M"}
+/type/redirect	/books/OL4M	2	2023-02-10T00:00:00	{"key": "/books/OL4M", "location": "/books/OL1M"}
+/type/edition	/books/OL5M	1	2023-02-10T00:00:00	{"key": "/books/OL5M"}
+`, modifiedAfter)
+
+	var editions []string
+	rows, err := store.db.Query(`
+	  SELECT ol.edition_id || ' ' || coalesce(ol.ocaid, '') || ' ' || coalesce(group_concat(ei.isbn_13), '')
+	  FROM ol LEFT JOIN edition_isbn ei ON ei.edition_id = ol.edition_id
+	  GROUP BY ol.edition_id ORDER BY ol.edition_id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var edition string
+		if err := rows.Scan(&edition); err != nil {
+			t.Fatal(err)
+		}
+		editions = append(editions, edition)
+	}
+	rows.Close()
+
+	exp := []string{"OL1M IA001 9780141439518", "OL2M IA002 9780135043943", "OL5M  "}
+	if !reflect.DeepEqual(exp, editions) {
+		t.Fatalf("expected editions %v, but got %v", exp, editions)
+	}
+
+	var recorded string
+	if err := store.db.QueryRow("SELECT modified_after FROM run WHERE id = 2").Scan(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != "2023-01-31T00:00:00" {
+		t.Fatalf("expected the run's modified_after to be recorded, but got %q", recorded)
+	}
+}
+
+func TestRunHashesStdin(t *testing.T) {
+	store, err := newSQLiteStore(filepath.Join(t.TempDir(), "test.db") + DBOPTIONS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+
+	// A gzipped dump on stdin, which is hashed as it arrives, before it's
+	// decompressed.
+	var dump bytes.Buffer
+	gz := gzip.NewWriter(&dump)
+	if _, err := gz.Write([]byte("/type/edition\t/books/OL1M\t1\t2020-12-22T19:20:44.396666\t{\"key\": \"/books/OL1M\"}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write(dump.Bytes())
+		w.Close()
+	}()
+
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = r
+
+	if err := loadDump(context.Background(), store, "ol", "-", NewOpenLibraryParser(nil, time.Time{}), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	var size int
+	var sha string
+	if err := store.db.QueryRow("SELECT dump_size, dump_sha256 FROM run").Scan(&size, &sha); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(dump.Bytes())
+	if size != dump.Len() || sha != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected a size of %d and SHA-256 %x, but got %d and %v", dump.Len(), sum, size, sha)
+	}
+}
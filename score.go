@@ -0,0 +1,273 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Signal is one piece of evidence, besides the ISBN, that an OL edition and an
+// IA item are the same book.
+type Signal int
+
+const (
+	SignalTitle Signal = iota
+	SignalYear
+	SignalPublisher
+	SignalPages
+	SignalLanguage
+	numSignals
+)
+
+var signalNames = [numSignals]string{
+	SignalTitle:     "title",
+	SignalYear:      "year",
+	SignalPublisher: "publisher",
+	SignalPages:     "pages",
+	SignalLanguage:  "language",
+}
+
+// signalWeights is how much each signal counts towards a Score's total.
+var signalWeights = [numSignals]float64{
+	SignalTitle:     0.4,
+	SignalYear:      0.2,
+	SignalPublisher: 0.15,
+	SignalPages:     0.15,
+	SignalLanguage:  0.1,
+}
+
+func (s Signal) String() string {
+	if s >= 0 && s < numSignals {
+		return signalNames[s]
+	}
+	return fmt.Sprintf("Signal(%d)", int(s))
+}
+
+// Score is how confident we are in a match, from 0 to 1, with the breakdown by
+// signal. A signal is only Valid when both sides have the data for it. The
+// total is the weighted sum of the valid signals over the weight of every
+// signal, so a missing signal counts as no evidence rather than being left
+// out, and one agreeing signal can't outscore a corroborated match. With no
+// valid signals the total is 0, so ISBN-only matches are never applied
+// automatically.
+type Score struct {
+	total   float64
+	signals [numSignals]sql.NullFloat64
+}
+
+// matchMetadata is the OL edition and IA item metadata a Score is based on.
+// Lists are ; separated, as in the ol and ia tables.
+type matchMetadata struct {
+	olTitle       string
+	olSubtitle    string
+	olPublishDate string
+	olPublishers  string
+	olPages       int
+	olLanguages   string
+
+	iaTitle      string
+	iaDate       string
+	iaPublishers string
+	iaImageCount int
+	iaLanguages  string
+}
+
+// scoreMatch scores how well the metadata of an OL edition and an IA item agree.
+func scoreMatch(m *matchMetadata) Score {
+	var s Score
+
+	if m.olTitle != "" && m.iaTitle != "" {
+		// IA titles often include the subtitle, so take the better of the two.
+		title := tokenSimilarity(m.olTitle, m.iaTitle, titleStopwords)
+		if m.olSubtitle != "" {
+			title = max(title, tokenSimilarity(m.olTitle+" "+m.olSubtitle, m.iaTitle, titleStopwords))
+		}
+		s.signals[SignalTitle] = validSignal(title)
+	}
+
+	if olYear, iaYear := getYear(m.olPublishDate), getYear(m.iaDate); olYear != 0 && iaYear != 0 {
+		s.signals[SignalYear] = validSignal(scoreYears(olYear, iaYear))
+	}
+
+	if m.olPublishers != "" && m.iaPublishers != "" {
+		var publisher float64
+		for _, olPublisher := range strings.Split(m.olPublishers, ";") {
+			for _, iaPublisher := range strings.Split(m.iaPublishers, ";") {
+				publisher = max(publisher, tokenSimilarity(olPublisher, iaPublisher, publisherStopwords))
+			}
+		}
+		s.signals[SignalPublisher] = validSignal(publisher)
+	}
+
+	if m.olPages > 0 && m.iaImageCount > 0 {
+		s.signals[SignalPages] = validSignal(scorePages(m.olPages, m.iaImageCount))
+	}
+
+	if m.olLanguages != "" && m.iaLanguages != "" {
+		var language float64
+		for _, olLanguage := range strings.Split(m.olLanguages, ";") {
+			for _, iaLanguage := range strings.Split(m.iaLanguages, ";") {
+				if toLanguageCode(iaLanguage) == olLanguage {
+					language = 1
+				}
+			}
+		}
+		s.signals[SignalLanguage] = validSignal(language)
+	}
+
+	var sum, weights float64
+	for i, signal := range s.signals {
+		if signal.Valid {
+			sum += signal.Float64 * signalWeights[i]
+		}
+		weights += signalWeights[i]
+	}
+	s.total = sum / weights
+
+	return s
+}
+
+// without returns s with signals left in its breakdown but no longer counted
+// towards its total, for signals a candidate was found by, which agree by
+// construction rather than as evidence.
+func (s Score) without(signals ...Signal) Score {
+	var weights float64
+	for _, weight := range signalWeights {
+		weights += weight
+	}
+
+	for _, signal := range signals {
+		if s.signals[signal].Valid {
+			s.total -= s.signals[signal].Float64 * signalWeights[signal] / weights
+		}
+	}
+	s.total = max(0, s.total)
+
+	return s
+}
+
+func validSignal(f float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: f, Valid: true}
+}
+
+// Words that say little about whether two titles or publishers match.
+var (
+	titleStopwords     = map[string]bool{"a": true, "an": true, "the": true, "and": true, "of": true}
+	publisherStopwords = map[string]bool{
+		"the": true, "and": true, "inc": true, "ltd": true, "co": true, "company": true,
+		"publisher": true, "publishers": true, "publishing": true, "press": true,
+	}
+)
+
+// tokenSimilarity returns the Jaccard similarity of the words in a and b, from
+// 0 to 1, ignoring case, punctuation and stopwords.
+func tokenSimilarity(a, b string, stopwords map[string]bool) float64 {
+	aTokens, bTokens := tokenize(a, stopwords), tokenize(b, stopwords)
+	if len(aTokens) == 0 || len(bTokens) == 0 {
+		return 0
+	}
+
+	var shared int
+	for token := range aTokens {
+		if bTokens[token] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(aTokens)+len(bTokens)-shared)
+}
+
+// tokenize returns the set of lower cased words in s, less stopwords.
+func tokenize(s string, stopwords map[string]bool) map[string]bool {
+	tokens := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !stopwords[word] {
+			tokens[word] = true
+		}
+	}
+	return tokens
+}
+
+// getYear returns the first plausible four digit year in a free text date,
+// such as "March 2006" or "c1999", or 0 if there isn't one.
+func getYear(date string) int {
+	for i := 0; i+4 <= len(date); i++ {
+		if !isDigit(date[i]) || !isDigit(date[i+1]) || !isDigit(date[i+2]) || !isDigit(date[i+3]) {
+			continue
+		}
+
+		// Skip longer numbers, such as ISBNs.
+		if (i > 0 && isDigit(date[i-1])) || (i+4 < len(date) && isDigit(date[i+4])) {
+			continue
+		}
+
+		if year, _ := strconv.Atoi(date[i : i+4]); year >= 1000 && year <= 2999 {
+			return year
+		}
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// scoreYears is 1 for the same year and 0.5 for an adjacent one, which is
+// common between a book's imprint and copyright dates.
+func scoreYears(a, b int) float64 {
+	switch a - b {
+	case 0:
+		return 1
+	case -1, 1:
+		return 0.5
+	}
+	return 0
+}
+
+// scorePages compares an edition's page count to the number of images in a
+// scan. Scans include covers and front matter, so some extra images are
+// expected; beyond that the score falls off with the difference.
+func scorePages(pages, imageCount int) float64 {
+	upper := float64(pages)*1.25 + 30
+
+	var diff float64
+	switch {
+	case imageCount < pages:
+		diff = float64(pages-imageCount) / float64(pages)
+	case float64(imageCount) > upper:
+		diff = (float64(imageCount) - upper) / float64(pages)
+	}
+
+	return max(0, 1-2*diff)
+}
+
+// languageCodes maps the language names IA items sometimes use to the MARC
+// codes Open Library uses.
+var languageCodes = map[string]string{
+	"english":    "eng",
+	"french":     "fre",
+	"german":     "ger",
+	"spanish":    "spa",
+	"italian":    "ita",
+	"portuguese": "por",
+	"dutch":      "dut",
+	"russian":    "rus",
+	"chinese":    "chi",
+	"japanese":   "jpn",
+	"latin":      "lat",
+	"arabic":     "ara",
+}
+
+// toLanguageCode converts an IA language, such as English or eng, to a MARC
+// language code.
+func toLanguageCode(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if code, ok := languageCodes[language]; ok {
+		return code
+	}
+	return language
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+)
+
+func TestScoreMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		metadata   matchMetadata
+		expTotal   float64
+		expSignals [numSignals]sql.NullFloat64
+	}{
+		{
+			name:     "NoMetadata",
+			metadata: matchMetadata{},
+		},
+		{
+			name: "AllSignalsAgree",
+			metadata: matchMetadata{
+				olTitle: "Jane Eyre", olSubtitle: "An Autobiography", olPublishDate: "2006", olPublishers: "Penguin Books",
+				olPages: 532, olLanguages: "eng",
+				iaTitle: "Jane Eyre : an autobiography", iaDate: "2006-01-01", iaPublishers: "Penguin", iaImageCount: 560,
+				iaLanguages: "English",
+			},
+			expTotal: 0.925,
+			expSignals: [numSignals]sql.NullFloat64{
+				{Float64: 1, Valid: true}, {Float64: 1, Valid: true}, {Float64: 0.5, Valid: true},
+				{Float64: 1, Valid: true}, {Float64: 1, Valid: true},
+			},
+		},
+		{
+			name: "WrongVolume",
+			metadata: matchMetadata{
+				olTitle: "History of England, Volume 1", olPublishDate: "1850", olPages: 400,
+				iaTitle: "History of England, Volume 2", iaDate: "1851", iaImageCount: 200,
+			},
+			// Title 3/5, year 0.5, pages 0, and nothing for the rest.
+			expTotal: 0.4*0.6 + 0.2*0.5,
+			expSignals: [numSignals]sql.NullFloat64{
+				{Float64: 0.6, Valid: true}, {Float64: 0.5, Valid: true}, {}, {Float64: 0, Valid: true}, {},
+			},
+		},
+		{
+			// One agreeing signal is weak evidence on its own.
+			name:       "LanguageOnly",
+			metadata:   matchMetadata{olLanguages: "eng", iaLanguages: "English"},
+			expTotal:   0.1,
+			expSignals: [numSignals]sql.NullFloat64{{}, {}, {}, {}, {Float64: 1, Valid: true}},
+		},
+		{
+			name:       "YearOnly",
+			metadata:   matchMetadata{olPublishDate: "1815", iaDate: "1815"},
+			expTotal:   0.2,
+			expSignals: [numSignals]sql.NullFloat64{{}, {Float64: 1, Valid: true}, {}, {}, {}},
+		},
+		{
+			// Sparse metadata that agrees scores below a fuller match with
+			// one weak disagreement.
+			name: "SparseMetadata",
+			metadata: matchMetadata{
+				olTitle: "Emma", olPublishDate: "1815",
+				iaTitle: "Emma", iaDate: "1815",
+			},
+			expTotal:   0.6,
+			expSignals: [numSignals]sql.NullFloat64{{Float64: 1, Valid: true}, {Float64: 1, Valid: true}, {}, {}, {}},
+		},
+		{
+			name: "CorroboratedWithOneDisagreement",
+			metadata: matchMetadata{
+				olTitle: "Emma", olPublishDate: "1815", olPublishers: "John Murray", olPages: 400, olLanguages: "eng",
+				iaTitle: "Emma", iaDate: "1815", iaPublishers: "Penguin", iaImageCount: 420, iaLanguages: "eng",
+			},
+			expTotal: 0.85,
+			expSignals: [numSignals]sql.NullFloat64{
+				{Float64: 1, Valid: true}, {Float64: 1, Valid: true}, {Float64: 0, Valid: true},
+				{Float64: 1, Valid: true}, {Float64: 1, Valid: true},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			score := scoreMatch(&tc.metadata)
+			if math.Abs(score.total-tc.expTotal) > 1e-9 {
+				t.Fatalf("expected a total of %v, but got %v", tc.expTotal, score.total)
+			}
+
+			for s, exp := range tc.expSignals {
+				res := score.signals[s]
+				if res.Valid != exp.Valid || math.Abs(res.Float64-exp.Float64) > 1e-9 {
+					t.Fatalf("%v: expected %v, but got %v", Signal(s), exp, res)
+				}
+			}
+		})
+	}
+}
+
+func TestGetYear(t *testing.T) {
+	tests := []struct {
+		date string
+		exp  int
+	}{
+		{date: "2006", exp: 2006},
+		{date: "March 2006", exp: 2006},
+		{date: "c1999.", exp: 1999},
+		{date: "2006-01-31", exp: 2006},
+		{date: "9780141439518 2006", exp: 2006},
+		{date: "0999", exp: 0},
+		{date: "n.d.", exp: 0},
+	}
+
+	for _, tc := range tests {
+		if res := getYear(tc.date); res != tc.exp {
+			t.Fatalf("%q: expected %d, but got %d", tc.date, tc.exp, res)
+		}
+	}
+}
+
+func TestScorePages(t *testing.T) {
+	tests := []struct {
+		pages      int
+		imageCount int
+		exp        float64
+	}{
+		{pages: 200, imageCount: 200, exp: 1},
+		{pages: 200, imageCount: 280, exp: 1},
+		{pages: 200, imageCount: 180, exp: 0.8},
+		{pages: 200, imageCount: 300, exp: 0.8},
+		{pages: 200, imageCount: 100, exp: 0},
+	}
+
+	for _, tc := range tests {
+		if res := scorePages(tc.pages, tc.imageCount); math.Abs(res-tc.exp) > 1e-9 {
+			t.Fatalf("%d pages, %d images: expected %v, but got %v", tc.pages, tc.imageCount, tc.exp, res)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqliteStore is the Store backed by the SQLite DB the reports query.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(dbName string) (*sqliteStore, error) {
+	db, err := getDB(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteStore{db: db}, nil
+}
+
+// writer records run as loading and returns a sqliteWriter for it, which
+// loads in a transaction. For a bulk load, the indexes on the tables of run's
+// kind are dropped until it's closed.
+func (s *sqliteStore) writer(run *loadRun, batchSize int) (recordWriter, error) {
+	if err := startRun(s.db, run); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, s.failRun(run, err)
+	}
+
+	if bulkLoad {
+		if err := dropIndexes(tx, run.kind); err != nil {
+			tx.Rollback()
+			return nil, s.failRun(run, err)
+		}
+	}
+
+	return &sqliteWriter{tx: tx, run: run, batchSize: batchSize}, nil
+}
+
+// failRun records run as failed with err, which is returned.
+func (s *sqliteStore) failRun(run *loadRun, err error) error {
+	if runErr := finishRun(s.db, run, err); runErr != nil {
+		return fmt.Errorf("%w (recording the run: %v)", err, runErr)
+	}
+
+	return err
+}
+
+// endRun records how run ended, then, if it completed, prunes the runs of its
+// kind beyond the latest keepRuns.
+func (s *sqliteStore) endRun(run *loadRun, loadErr error) error {
+	if err := finishRun(s.db, run, loadErr); err != nil {
+		return err
+	}
+
+	if loadErr != nil || keepRuns == 0 {
+		return nil
+	}
+
+	_, err := pruneRuns(s.db, run.kind, keepRuns)
+	return err
+}
+
+// sqliteEditionQuery selects editions in the order queryEditions reads
+// them. The condition is appended.
+const sqliteEditionQuery = `
+  SELECT edition_id, coalesce(ocaid, ''), coalesce(isbn_13, ''), coalesce(title, ''), coalesce(subtitle, ''),
+    coalesce(publishers, ''), coalesce(publish_date, ''), coalesce(number_of_pages, 0), coalesce(languages, ''),
+    coalesce(source_records, ''), coalesce(revision, 0), coalesce(last_modified, ''),
+    coalesce((SELECT group_concat(isbn_13) FROM edition_isbn ei WHERE ei.edition_id = ol.edition_id), '')
+  FROM ol
+  `
+
+func (s *sqliteStore) editionByOlid(olid string) (*OpenLibraryEdition, error) {
+	var edition *OpenLibraryEdition
+	if err := s.queryEditions(func(e *OpenLibraryEdition) error {
+		edition = e
+		return nil
+	}, "WHERE edition_id = ? LIMIT 1", olid); err != nil {
+		return nil, err
+	}
+
+	if edition == nil {
+		return nil, fmt.Errorf("%v: %w", olid, ErrorNotFound)
+	}
+	return edition, nil
+}
+
+func (s *sqliteStore) editionsByIsbn(isbn13 string) ([]*OpenLibraryEdition, error) {
+	return s.collectEditions(
+		"WHERE edition_id IN (SELECT edition_id FROM edition_isbn WHERE isbn_13 = ?) ORDER BY edition_id", isbn13,
+	)
+}
+
+func (s *sqliteStore) editionsByOcaid(ocaid string) ([]*OpenLibraryEdition, error) {
+	return s.collectEditions("WHERE ocaid = ? ORDER BY edition_id", ocaid)
+}
+
+func (s *sqliteStore) editions(fn func(e *OpenLibraryEdition) error) error {
+	return s.queryEditions(fn, "ORDER BY edition_id")
+}
+
+func (s *sqliteStore) linkCandidates(fn func(c *LinkCandidate) error) error {
+	return getLinkCandidates(s.db, fn)
+}
+
+func (s *sqliteStore) close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStore) collectEditions(condition string, args ...interface{}) ([]*OpenLibraryEdition, error) {
+	var editions []*OpenLibraryEdition
+	err := s.queryEditions(func(e *OpenLibraryEdition) error {
+		editions = append(editions, e)
+		return nil
+	}, condition, args...)
+
+	return editions, err
+}
+
+// queryEditions calls fn with each edition from sqliteEditionQuery with
+// condition.
+func (s *sqliteStore) queryEditions(fn func(e *OpenLibraryEdition) error, condition string, args ...interface{}) error {
+	rows, err := s.db.Query(sqliteEditionQuery+condition, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e OpenLibraryEdition
+		var publishers, languages, sourceRecords, lastModified, isbns string
+		if err := rows.Scan(
+			&e.olid, &e.ocaid, &e.isbn13, &e.title, &e.subtitle, &publishers, &e.publishDate, &e.numberOfPages,
+			&languages, &sourceRecords, &e.revision, &lastModified, &isbns,
+		); err != nil {
+			return err
+		}
+
+		e.publishers = splitStored(publishers, ";")
+		e.languages = splitStored(languages, ";")
+		e.sourceRecords = splitStored(sourceRecords, ";")
+		e.isbns = splitStored(isbns, ",")
+		if lastModified != "" {
+			if e.lastModified, err = time.Parse(LASTMODIFIEDLAYOUT, lastModified); err != nil {
+				return fmt.Errorf("%v %q: %w", e.olid, lastModified, ErrorBadRevision)
+			}
+		}
+
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// splitStored splits a list stored as text, where "" is an empty list.
+func splitStored(s, sep string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, sep)
+}
+
+// sqliteWriter is the recordWriter for the SQLite store. It hands each record
+// to an olWriter, iaWriter or marcWriter, by type, creating each when it's
+// first needed, builds any missing indexes on the run's tables once they're
+// all closed, then lays a -modified-after load over the previous snapshot. The
+// whole load is one transaction, committed by close or rolled back by abort,
+// so a load that fails leaves the DB as it was.
+type sqliteWriter struct {
+	tx        *sql.Tx
+	run       *loadRun
+	batchSize int
+	ol        recordWriter
+	ia        recordWriter
+	marc      recordWriter
+}
+
+// add fails for a record of another kind than the run's, as its rows would be
+// in another kind's tables, tagged with a run of the wrong kind.
+func (w *sqliteWriter) add(record Record) error {
+	kind := recordKind(record)
+	if kind != w.run.kind {
+		return fmt.Errorf("%T in a %v run: %w", record, w.run.kind, ErrorWrongRunKind)
+	}
+
+	var writer *recordWriter
+	var newWriter func(db sqlExecer, runID int64, batchSize int) (recordWriter, error)
+	switch kind {
+	case "ia":
+		writer, newWriter = &w.ia, newIAWriter
+	case "marc":
+		writer, newWriter = &w.marc, newMARCWriter
+	default:
+		writer, newWriter = &w.ol, newOLWriter
+	}
+
+	if *writer == nil {
+		var err error
+		if *writer, err = newWriter(w.tx, w.run.id, w.batchSize); err != nil {
+			return err
+		}
+	}
+
+	return (*writer).add(record)
+}
+
+func (w *sqliteWriter) close() error {
+	for _, writer := range []recordWriter{w.ol, w.ia, w.marc} {
+		if writer == nil {
+			continue
+		}
+		if err := writer.close(); err != nil {
+			return err
+		}
+	}
+
+	// layerRun looks up the editions this run loaded, so the indexes are
+	// built first, and analyzed again once it has added the rest.
+	if err := createIndexes(w.tx, w.run.kind); err != nil {
+		return err
+	}
+
+	if !w.run.modifiedAfter.IsZero() {
+		if err := layerRun(w.tx, w.run); err != nil {
+			return err
+		}
+		if err := analyzeIndexes(w.tx, w.run.kind); err != nil {
+			return err
+		}
+	}
+
+	return w.tx.Commit()
+}
+
+// abort rolls back the load. The writers' statements are closed with it.
+func (w *sqliteWriter) abort() error {
+	if err := w.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Store is where loaded records are kept and queried. The SQLite store holds
+// everything and backs every report; the bolt store is an embedded key-value
+// store, for benchmarking and for builds without cgo, that holds editions and
+// IA items and supports ISBN reconciliation.
+type Store interface {
+	// writer starts run and returns a recordWriter that batch writes its
+	// records, batchSize at a time.
+	writer(run *loadRun, batchSize int) (recordWriter, error)
+
+	// endRun records how run ended, where loadErr is why it failed, if it
+	// did.
+	endRun(run *loadRun, loadErr error) error
+
+	// editionByOlid returns the edition with olid, or ErrorNotFound. The
+	// edition has the fields stored in the ol table, and its ISBNs.
+	editionByOlid(olid string) (*OpenLibraryEdition, error)
+
+	// editionsByIsbn and editionsByOcaid return the editions with an ISBN
+	// or ocaid, ordered by OLID.
+	editionsByIsbn(isbn13 string) ([]*OpenLibraryEdition, error)
+	editionsByOcaid(ocaid string) ([]*OpenLibraryEdition, error)
+
+	// editions calls fn with each edition, ordered by OLID.
+	editions(fn func(e *OpenLibraryEdition) error) error
+
+	// linkCandidates calls fn with each scored LinkCandidate.
+	linkCandidates(fn func(c *LinkCandidate) error) error
+
+	close() error
+}
+
+// storeBackends are the backends openStore supports.
+var storeBackends = []string{"sqlite", "bolt"}
+
+// storeBackend is set from -store.
+var storeBackend = "sqlite"
+
+// openStore opens the default store for backend, one of storeBackends. Only
+// the SQLite store keeps runs, so bolt can't be used with -keep-runs.
+func openStore(backend string) (Store, error) {
+	switch backend {
+	case "sqlite":
+		return newSQLiteStore(DBNAME)
+	case "bolt":
+		if keepRuns != 0 {
+			return nil, fmt.Errorf("-keep-runs %d: %w", keepRuns, ErrorNoRuns)
+		}
+		return newBoltStore(BOLTFILE)
+	}
+
+	return nil, fmt.Errorf("%v (want one of %v): %w", backend, strings.Join(storeBackends, ", "), ErrorUnknownStore)
+}
+
+// runStoreReport writes the report named reportType from the default store
+// for backend to out in format. Only reconcile works with every store; the
+// other reports are SQL, so they need runReport and the SQLite store, as does
+// narrowing reconcile with -modified-after.
+func runStoreReport(reportType, backend, format string, minScore float64, modifiedAfter time.Time, out io.Writer) error {
+	if reportType == "runs" && backend == "bolt" {
+		return fmt.Errorf("%v: %w", reportType, ErrorNoRuns)
+	}
+	if reportType != "reconcile" {
+		return fmt.Errorf("%v with the %v store: %w", reportType, backend, ErrorNeedsSQLite)
+	}
+	if !modifiedAfter.IsZero() {
+		return fmt.Errorf("%v -modified-after with the %v store: %w", reportType, backend, ErrorNeedsSQLite)
+	}
+
+	store, err := openStore(backend)
+	if err != nil {
+		return err
+	}
+	defer store.close()
+
+	w, err := newReportWriter(format, "reconcile-go "+reportType, out)
+	if err != nil {
+		return err
+	}
+
+	if err := writeLinkCandidates(store, minScore, w); err != nil {
+		return err
+	}
+
+	return w.close()
+}
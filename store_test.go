@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStores(t *testing.T) {
+	records := []Record{
+		&OpenLibraryEdition{
+			olid: "OL001M", isbn13: "9780141439518", isbns: []string{"9780141439518"},
+			title: "Great Expectations", publishers: []string{"Penguin"},
+		},
+		// Already has an ocaid.
+		&OpenLibraryEdition{olid: "OL002M", ocaid: "IA999", isbn13: "9780135043943", isbns: []string{"9780135043943"}},
+		// Share an ISBN, so it's ambiguous.
+		&OpenLibraryEdition{olid: "OL004M", isbn13: "9788955565683", isbns: []string{"9788955565683"}},
+		&OpenLibraryEdition{olid: "OL003M", isbn13: "9788955565683", isbns: []string{"9788955565683"}},
+		// A second scan of OL001M, loaded first but sorted after IA001.
+		&IAItem{identifier: "IA005", isbns: []string{"9780141439518"}},
+		&IAItem{identifier: "IA001", isbns: []string{"9780141439518"}, title: "Great Expectations"},
+		&IAItem{identifier: "IA002", isbns: []string{"9788955565683"}},
+		&IAItem{identifier: "IA003", isbns: []string{"9780135043943"}},
+		// Already linked.
+		&IAItem{identifier: "IA004", isbns: []string{"9780141439518"}, olEdition: "OL001M"},
+	}
+
+	tests := []struct {
+		backend  string
+		newStore func(dir string) (Store, error)
+	}{
+		{"sqlite", func(dir string) (Store, error) { return newSQLiteStore(filepath.Join(dir, "test.db") + DBOPTIONS) }},
+		{"bolt", func(dir string) (Store, error) { return newBoltStore(filepath.Join(dir, "test.bolt")) }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.backend, func(t *testing.T) {
+			store, err := tc.newStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.close()
+
+			// The editions and IA items are each their own run. A batch size
+			// of 2 ensures "underflow" batches are handled.
+			for _, kind := range []string{"ol", "ia"} {
+				recordsCh := make(chan Record)
+				go func() {
+					defer close(recordsCh)
+					for _, record := range records {
+						if recordKind(record) == kind {
+							recordsCh <- record
+						}
+					}
+				}()
+
+				run := newLoadRun(kind, "test")
+				writer, err := store.writer(run, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := addRecordsToDBBatch(context.Background(), recordsCh, writer); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.endRun(run, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			edition, err := store.editionByOlid("OL001M")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if edition.title != "Great Expectations" || !reflect.DeepEqual(edition.publishers, []string{"Penguin"}) ||
+				!reflect.DeepEqual(edition.isbns, []string{"9780141439518"}) {
+				t.Fatalf("unexpected edition %+v", edition)
+			}
+
+			if _, err := store.editionByOlid("OL999M"); !errors.Is(err, ErrorNotFound) {
+				t.Fatalf("expected %v, but got %v", ErrorNotFound, err)
+			}
+
+			olids := func(editions []*OpenLibraryEdition) []string {
+				var olids []string
+				for _, e := range editions {
+					olids = append(olids, e.olid)
+				}
+				return olids
+			}
+
+			editions, err := store.editionsByIsbn("9788955565683")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := []string{"OL003M", "OL004M"}; !reflect.DeepEqual(olids(editions), exp) {
+				t.Fatalf("expected %v, but got %v", exp, olids(editions))
+			}
+
+			editions, err = store.editionsByOcaid("IA999")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := []string{"OL002M"}; !reflect.DeepEqual(olids(editions), exp) {
+				t.Fatalf("expected %v, but got %v", exp, olids(editions))
+			}
+
+			editions = nil
+			if err := store.editions(func(e *OpenLibraryEdition) error {
+				editions = append(editions, e)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if exp := []string{"OL001M", "OL002M", "OL003M", "OL004M"}; !reflect.DeepEqual(olids(editions), exp) {
+				t.Fatalf("expected %v, but got %v", exp, olids(editions))
+			}
+
+			var candidates []string
+			if err := store.linkCandidates(func(c *LinkCandidate) error {
+				candidates = append(candidates, c.olid+" "+c.ocaid+" "+c.isbn13+" "+string(c.reason))
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if exp := []string{"OL001M IA001 9780141439518 unique_isbn", "OL001M IA005 9780141439518 unique_isbn"}; !reflect.DeepEqual(candidates, exp) {
+				t.Fatalf("expected %v, but got %v", exp, candidates)
+			}
+		})
+	}
+}
+
+func TestBoltRuns(t *testing.T) {
+	defer func(keep int) { keepRuns = keep }(keepRuns)
+	keepRuns = 2
+
+	if _, err := openStore("bolt"); !errors.Is(err, ErrorNoRuns) {
+		t.Fatalf("expected %v, but got %v", ErrorNoRuns, err)
+	}
+
+	if err := runStoreReport("runs", "bolt", "tsv", 0, time.Time{}, io.Discard); !errors.Is(err, ErrorNoRuns) {
+		t.Fatalf("expected %v, but got %v", ErrorNoRuns, err)
+	}
+}
+
+func TestBoltLoadRecovery(t *testing.T) {
+	tests := []struct {
+		name  string
+		state []byte
+		exp   []string
+	}{
+		// Interrupted while staging, so the load is dropped.
+		{name: "Loading", state: boltLoading},
+		// Interrupted while folding in, so the load is finished.
+		{name: "Folding", state: boltFolding, exp: []string{"OL001M", "OL002M"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.bolt")
+			store, err := newBoltStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A batch size of 2 commits both editions to staging.
+			writer, err := store.writer(newLoadRun("ol", "test"), 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, olid := range []string{"OL002M", "OL001M"} {
+				if err := writer.add(&OpenLibraryEdition{olid: olid, isbns: []string{"9780141439518"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := store.db.Update(func(tx *bolt.Tx) error {
+				if n := tx.Bucket(boltStaging).Bucket(boltEditions).Stats().KeyN; n != 2 {
+					t.Fatalf("expected 2 staged editions, but got %d", n)
+				}
+				return tx.Bucket(boltMeta).Put(boltLoadState, tc.state)
+			}); err != nil {
+				t.Fatal(err)
+			}
+			store.close()
+
+			store, err = newBoltStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.close()
+
+			var olids []string
+			if err := store.editions(func(e *OpenLibraryEdition) error {
+				olids = append(olids, e.olid)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(olids, tc.exp) {
+				t.Fatalf("expected %v, but got %v", tc.exp, olids)
+			}
+
+			editions, err := store.editionsByIsbn("9780141439518")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(editions) != len(tc.exp) {
+				t.Fatalf("expected %d indexed editions, but got %d", len(tc.exp), len(editions))
+			}
+
+			if err := store.db.View(func(tx *bolt.Tx) error {
+				if tx.Bucket(boltStaging) != nil || tx.Bucket(boltMeta).Get(boltLoadState) != nil {
+					t.Fatal("expected the load to be cleaned up")
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
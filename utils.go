@@ -2,74 +2,140 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"database/sql"
-	"errors"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
 	"strings"
 )
 
-// getIsbn13CheckDigit calculates the check digit for an ISBN 13 based on the
-// first twelve digits of the number. Works with a full ISBN 13 or just the
-// first 12 digits.
-// NOTE: This does *NOT* verify that the ISBN 10, and therefore ISBN 13, is valid,
-// so it can produce invalid ISBN 13s based on invalid ISBN 10s.
-func getIsbn13CheckDigit(isbn string) (string, error) {
-	chars := strings.Split(isbn, "")
-	chars = chars[:12]
-	var checkDigit string
-	var sum int
-
-	// Formula adapted from xlcnd/isbnlib
-	// https://github.com/xlcnd/isbnlib/blob/f4e7339ced8d42939318ce3adc7823a45fcd1c5b/isbnlib/_core.py#L77
-	for i, v := range chars {
-
-		val, err := strconv.Atoi(v)
-		if err != nil {
-			// Ignore errors that crop up from trying to convert characters such as "w" or "/".
-			if errors.Is(err, strconv.ErrSyntax) {
-				return "", nil
-			}
-			return "", err
-		}
+// getDB gets a SQLite DB based on the name, such as ":memory:", migrated to
+// the current schema.
+func getDB(dbName string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbName)
+	if err != nil {
+		return nil, err
+	}
 
-		sum += (i%2*2 + 1) * val
+	err = db.Ping()
+	if err != nil {
+		return nil, err
 	}
 
-	checkDigit = strconv.Itoa(10 - sum%10)
-	if checkDigit == "10" {
-		checkDigit = "0"
+	// Initalize or upgrade the DB if necessary.
+	if err := migrate(db); err != nil {
+		return nil, err
 	}
-	return checkDigit, nil
+
+	return db, nil
 }
 
-// getDB gets a SQLite DB based on the name, such as ":memory:".
-func getDB(dbName string) (*sql.DB, error) {
-	OLSCHEMA := `
-  CREATE TABLE IF NOT EXISTS ol (
-    id INTEGER NOT NULL PRIMARY KEY,
-    edition_id text,
-    ocaid text,
-    isbn_13 text
-  );`
+// sqlExecer is the part of a *sql.DB or *sql.Tx that writers need, so a load
+// can run in a transaction.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+}
 
-	db, err := sql.Open("sqlite3", dbName)
+// batchInserter uses batching for faster DB inserts: rows are buffered and
+// inserted batchSize at a time with a prepared multi-row INSERT.
+// Thanks to https://github.com/h12w/sqlite-benchmark/blob/master/main.go
+type batchInserter struct {
+	db        sqlExecer
+	table     string
+	columns   []string
+	batchSize int
+	stmt      *sql.Stmt
+	batch     []interface{}
+	tag       []interface{} // Appended to every row, such as a run_id.
+}
+
+func newBatchInserter(db sqlExecer, table string, columns []string, batchSize int) (*batchInserter, error) {
+	// Prepared statement for speed increase.
+	stmt, err := db.Prepare(getInsertStmt(table, columns, batchSize))
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Ping()
+	return &batchInserter{
+		db:        db,
+		table:     table,
+		columns:   columns,
+		batchSize: batchSize,
+		stmt:      stmt,
+		batch:     make([]interface{}, 0, batchSize*len(columns)),
+	}, nil
+}
+
+// newRunInserter is newBatchInserter for one of the runKinds' tables: rows go
+// to <table>_all, tagged with runID.
+func newRunInserter(db sqlExecer, runID int64, table string, columns []string, batchSize int) (*batchInserter, error) {
+	b, err := newBatchInserter(db, table+"_all", append(columns[:len(columns):len(columns)], "run_id"), batchSize)
 	if err != nil {
 		return nil, err
 	}
 
-	// Initalize the DB if necessary.
-	if _, err := db.Exec(OLSCHEMA); err != nil {
-		return nil, err
+	b.tag = []interface{}{runID}
+	return b, nil
+}
+
+// getInsertStmt returns an INSERT statement for table with placeholders for
+// rows rows, e.g. INSERT INTO ol (edition_id, ocaid) VALUES (?, ?),(?, ?).
+func getInsertStmt(table string, columns []string, rows int) string {
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+
+	// Preallocate roughly enough bytes for the full statement.
+	buf := bytes.NewBuffer(make([]byte, 0, (len(placeholders)+1)*rows+64))
+	fmt.Fprintf(buf, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(placeholders)
 	}
 
-	return db, nil
+	return buf.String()
+}
+
+// add queues a row, inserting the batch once it's full. values must be in the
+// same order as the columns.
+func (b *batchInserter) add(values ...interface{}) error {
+	b.batch = append(b.batch, values...)
+	b.batch = append(b.batch, b.tag...)
+
+	if len(b.batch)/len(b.columns) < b.batchSize {
+		return nil
+	}
+
+	if _, err := b.stmt.Exec(b.batch...); err != nil {
+		return err
+	}
+
+	// "Reset" batch for next round.
+	b.batch = b.batch[0:0]
+	return nil
+}
+
+// close inserts the final, partially filled batch and closes the prepared
+// statement.
+func (b *batchInserter) close() error {
+	if err := b.stmt.Close(); err != nil {
+		return err
+	}
+
+	rows := len(b.batch) / len(b.columns)
+	if rows == 0 {
+		return nil
+	}
+
+	if _, err := b.db.Exec(getInsertStmt(b.table, b.columns, rows), b.batch...); err != nil {
+		return err
+	}
+
+	b.batch = b.batch[0:0]
+	return nil
 }
 
 // Chunk provides an interface for working with files in need of parsing.
@@ -77,26 +143,31 @@ type Chunk struct {
 	filename string
 	start    int64
 	end      int64
-	// Possibly add parserFunc, so the OL or IA parser func can be added.
+	parser   Parser // E.g. the OL or IA parser.
 }
 
-func NewChunk(filename string, start int64, end int64) *Chunk {
+func NewChunk(filename string, start int64, end int64, parser Parser) *Chunk {
 	return &Chunk{
 		filename: filename,
 		start:    start,
 		end:      end,
+		parser:   parser,
 	}
 }
 
-func (c *Chunk) Process(editionsCh chan<- *OpenLibraryEdition, errCh chan<- error) {
+// Process parses the lines between c.start and c.end with c.parser and sends
+// the records to recordsCh. Errors reading the file stop it, as does ctx being
+// cancelled, while parse errors go to errCh.
+func (c *Chunk) Process(ctx context.Context, recordsCh chan<- Record, errCh chan<- error) error {
 	f, err := os.Open(c.filename)
 	if err != nil {
-		errCh <- err
-		return
+		return err
 	}
 	defer f.Close()
 
-	f.Seek(c.start, 0)
+	if _, err := f.Seek(c.start, 0); err != nil {
+		return err
+	}
 	byteCount := int64(-1) // Fix off-by-one.
 
 	sc := bufio.NewScanner(f)
@@ -112,29 +183,69 @@ func (c *Chunk) Process(editionsCh chan<- *OpenLibraryEdition, errCh chan<- erro
 			break
 		}
 
-		edition, err := parseOLLine(line)
-		if err != nil {
-			// if errors.Is(err, ErrorWrongColCount) || errors.Is(err, ErrorNotEdition) {
-			if errors.Is(err, ErrorNotEdition) {
-				continue
-			} else {
-				errCh <- err
-			}
+		if err := processLine(ctx, c.parser, line, recordsCh, errCh); err != nil {
+			return err
 		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("scanner error near byte %v: %w", c.start+byteCount, err)
+	}
+
+	return nil
+}
+
+// streamLines reads r line by line and sends the lines to linesCh in batches
+// of batchSize. This is the counterpart to getChunks for input that can't be
+// seeked, such as a compressed dump: one GoRoutine reads while the workers
+// parse the batches in parallel. split is usually bufio.ScanLines, but dumps
+// with other record separators, such as binary MARC, can pass their own. It
+// stops if ctx is cancelled.
+func streamLines(ctx context.Context, r io.Reader, split bufio.SplitFunc, batchSize int, linesCh chan<- [][]byte) error {
+	sc := bufio.NewScanner(r)
+	sc.Split(split)
+	buf := make([]byte, 10*1000)
+	sc.Buffer(buf, 10*1000*1000)
+
+	batch := make([][]byte, 0, batchSize)
+	for sc.Scan() {
+		// The scanner reuses its buffer, so each line must be copied.
+		line := make([]byte, len(sc.Bytes()))
+		copy(line, sc.Bytes())
+		batch = append(batch, line)
 
-		editionsCh <- edition
+		if len(batch) == batchSize {
+			if err := sendLines(ctx, batch, linesCh); err != nil {
+				return err
+			}
+			batch = make([][]byte, 0, batchSize)
+		}
 	}
 
 	if err := sc.Err(); err != nil {
-		errCh <- fmt.Errorf("error near byte: %v", byteCount)
-		errCh <- fmt.Errorf("scanner error: %w", err)
+		return fmt.Errorf("scanner error: %w", err)
+	}
+
+	if len(batch) > 0 {
+		return sendLines(ctx, batch, linesCh)
+	}
+
+	return nil
+}
+
+func sendLines(ctx context.Context, batch [][]byte, linesCh chan<- [][]byte) error {
+	select {
+	case linesCh <- batch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
 // Read a file and break it into chunks of start+end offsets in
 // bytes so that the file can be read in chunks.
-// Chunks start/end on a new line character.
-func getChunks(chunkSize int64, filename string) ([]*Chunk, error) {
+// Chunks start/end on a new line character, and are parsed with parser.
+func getChunks(chunkSize int64, filename string, parser Parser) ([]*Chunk, error) {
 	chunks := []*Chunk{}
 	readAhead := int64(10 * 1000)
 	chunkEndOffset := int64(0)
@@ -162,7 +273,7 @@ func getChunks(chunkSize int64, filename string) ([]*Chunk, error) {
 
 		// At the end of the file, create the last chunk and break out of the loop.
 		if chunkEndOffset >= fileEnd {
-			chunk := NewChunk(filename, chunkStart, fileEnd)
+			chunk := NewChunk(filename, chunkStart, fileEnd, parser)
 			chunks = append(chunks, chunk)
 			break
 		}
@@ -185,7 +296,7 @@ func getChunks(chunkSize int64, filename string) ([]*Chunk, error) {
 		for i := range readAheadBuf {
 			if readAheadBuf[i] == '\n' {
 				chunkEndOffset = currentOffset + int64(i)
-				chunk := NewChunk(filename, chunkStart, chunkEndOffset)
+				chunk := NewChunk(filename, chunkStart, chunkEndOffset, parser)
 				chunks = append(chunks, chunk)
 				chunkStart = chunkEndOffset + 1 // start on the newline character.
 				break
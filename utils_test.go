@@ -5,29 +5,6 @@ import (
 	"testing"
 )
 
-// TestCheckDigit13 verifies a few check digits for ISBN 13.
-func TestCheckDigit13(t *testing.T) {
-	tests := []struct {
-		isbn string
-		exp  string
-	}{
-		{isbn: "978819010750", exp: "1"},
-		{isbn: "9781590368923", exp: "3"},
-		{isbn: "9781590368930", exp: "0"},
-	}
-
-	for _, tc := range tests {
-		res, err := getIsbn13CheckDigit(tc.isbn)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		if res != tc.exp {
-			t.Fatalf("Expected %s, but got %s", tc.exp, res)
-		}
-	}
-}
-
 // TestGetChunks parses a test file for comparison against predetermined
 // outputs.
 func TestGetChunks(t *testing.T) {
@@ -53,7 +30,7 @@ func TestGetChunks(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			resChunks, err := getChunks(tc.chunkSize, "./testdata/chunkTestData.txt")
+			resChunks, err := getChunks(tc.chunkSize, "./testdata/chunkTestData.txt", nil)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -66,3 +43,11 @@ func TestGetChunks(t *testing.T) {
 		})
 	}
 }
+
+func TestGetInsertStmt(t *testing.T) {
+	res := getInsertStmt("ol", []string{"edition_id", "ocaid"}, 3)
+	exp := "INSERT INTO ol (edition_id, ocaid) VALUES (?, ?),(?, ?),(?, ?)"
+	if res != exp {
+		t.Fatalf("Expected %s, but got %s", exp, res)
+	}
+}